@@ -1,27 +1,62 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"regexp"
+	"slices"
 
+	"github.com/ahalbert/ted/ted"
+	"github.com/ahalbert/ted/ted/checker"
 	"github.com/ahalbert/ted/ted/flags"
 	"github.com/ahalbert/ted/ted/lexer"
-	"github.com/ahalbert/ted/ted/parser"
 	"github.com/ahalbert/ted/ted/runner"
 	"github.com/ahalbert/ted/ted/token"
 	"github.com/alexflint/go-arg"
 )
 
+// Exit statuses, documented under "Exit status" in the README. A program
+// that runs exit N exits with N.
+const (
+	exitParseError   = 1
+	exitUsageError   = 2
+	exitRuntimeError = 3
+	exitIOError      = 4
+)
+
+func fail(status int, a ...any) {
+	fmt.Fprintln(os.Stderr, a...)
+	os.Exit(status)
+}
+
+// exitStatus maps an error returned by the runner to the status ted exits
+// with. A runtime error outranks exit N, which outranks unreadable files.
+func exitStatus(err error) int {
+	var runtimeErr *runner.RuntimeError
+	var exitErr *runner.ExitError
+	var ioErr *runner.IOError
+	if errors.As(err, &runtimeErr) {
+		return exitRuntimeError
+	} else if errors.As(err, &exitErr) {
+		return exitErr.Code
+	} else if errors.As(err, &ioErr) {
+		return exitIOError
+	}
+	return exitRuntimeError
+}
+
 func main() {
 
+	os.Args = append(os.Args[:1], flags.ExtractInPlace(os.Args[1:])...)
 	arg.MustParse(&flags.Flags)
 	var program string
 	if flags.Flags.ProgramFile != "" {
 		buf, err := os.ReadFile(flags.Flags.ProgramFile)
 		if err != nil {
-			panic("FSA File " + flags.Flags.ProgramFile + " not found")
+			fail(exitIOError, "ted:", err)
 		}
 		program = string(buf)
 		if flags.Flags.Program != "" {
@@ -32,7 +67,7 @@ func main() {
 	}
 
 	if program == "" {
-		panic("no FSA supplied")
+		fail(exitUsageError, "ted: no FSA supplied")
 	}
 
 	if flags.Flags.DebugMode {
@@ -42,65 +77,115 @@ func main() {
 		}
 	}
 
-	l := lexer.New(program)
-	p := parser.New(l)
-
-	parsedFSA, errors := p.ParseFSA()
-	if len(errors) > 0 {
-		for _, err := range errors {
-			fmt.Println(err)
-		}
-		os.Exit(1)
+	prog, err := ted.CompileNamed(flags.Flags.ProgramFile, program)
+	if err != nil {
+		fail(exitParseError, err)
 	}
 
 	if flags.Flags.DebugMode {
-		io.WriteString(os.Stdout, parsedFSA.String())
+		io.WriteString(os.Stdout, prog.String())
 	}
 
-	variables := make(map[string]string)
-	if flags.Flags.Seperator == "" {
-		variables["$RS"] = "\n"
-	} else {
-		variables["$RS"] = flags.Flags.Seperator
+	opts := ted.Options{
+		Variables: make(map[string]string),
+		Separator: flags.Flags.Seperator,
+		NoPrint:   flags.Flags.NoPrint,
+		RegexSep:  flags.Flags.RegexSep,
+		FieldSep:  flags.Flags.FieldSep,
+		Format:    flags.Flags.Format,
+		History:   flags.Flags.History,
+		PerFile:   flags.Flags.PerFile,
+		LoopLimit: flags.Flags.LoopLimit,
 	}
-
-	if flags.Flags.NoPrint {
-		variables["$PRINTMODE"] = "noprint"
-	} else {
-		variables["$PRINTMODE"] = "print"
+	if opts.History <= 0 {
+		opts.History = -1
 	}
-
-	for _, varstring := range flags.Flags.Variables {
-		re, err := regexp.Compile("(.*?)=(.*)")
-		if err != nil {
-			panic("regex compile error")
+	if opts.LoopLimit <= 0 {
+		opts.LoopLimit = -1
+	}
+	if !slices.Contains([]string{"", "text", "csv", "tsv", "jsonl"}, opts.Format) {
+		fail(exitUsageError, "ted: unknown --format "+opts.Format+", expected csv, tsv or jsonl")
+	}
+	if opts.RegexSep {
+		if _, err := regexp.Compile(flags.Flags.Seperator); err != nil {
+			fail(exitUsageError, "ted: --seperator is not a valid regex:", err)
 		}
+	}
+	re := regexp.MustCompile("(.*?)=(.*)")
+	for _, varstring := range flags.Flags.Variables {
 		matches := re.FindStringSubmatch(varstring)
 		if matches != nil {
-			variables[matches[1]] = matches[2]
+			opts.Variables[matches[1]] = matches[2]
 		} else {
-			panic("unparsable variable --var " + varstring)
+			fail(exitUsageError, "ted: unparsable variable --var "+varstring+", expected NAME=VALUE")
 		}
 	}
 
-	r := runner.NewRunner(parsedFSA, variables)
-	if len(flags.Flags.InputFiles) > 0 {
-		for _, infile := range flags.Flags.InputFiles {
-			reader, err := os.Open(infile)
-			if err != nil {
-				panic("Input file " + infile + " not found")
+	if flags.Flags.Check {
+		failed := false
+		for _, diagnostic := range prog.Check(opts) {
+			fmt.Println(diagnostic)
+			if diagnostic.Severity == checker.Error {
+				failed = true
 			}
-			r.RunFSAFromFile(reader, os.Stdout)
 		}
-	} else {
-		stdin, err := io.ReadAll(os.Stdin)
+		if failed {
+			os.Exit(exitParseError)
+		}
+		return
+	}
+
+	if flags.Flags.Graph != "" {
+		err := prog.WriteGraph(os.Stdout, flags.Flags.Graph)
 		if err != nil {
-			panic(err)
+			fail(exitUsageError, "ted:", err)
+		}
+		return
+	}
+
+	status := 0
+	if flags.Flags.InPlace {
+		if len(flags.Flags.InputFiles) == 0 {
+			fail(exitUsageError, "ted: -i needs input files to edit")
 		}
-		str := string(stdin)
-		if len(str) > 0 {
-			str = str[:len(str)-1]
+		for _, infile := range flags.Flags.InputFiles {
+			err := prog.RunInPlace(context.Background(), infile, flags.Flags.Backup, opts)
+			var exitErr *runner.ExitError
+			if err != nil && !errors.As(err, &exitErr) {
+				report(err)
+				os.Exit(exitStatus(err))
+			} else if err != nil {
+				status = exitErr.Code
+			}
+		}
+	} else if len(flags.Flags.InputFiles) > 0 {
+		//Like cat, a missing file is reported and the rest are still processed.
+		if err := prog.RunFiles(context.Background(), flags.Flags.InputFiles, os.Stdout, opts); err != nil {
+			report(err)
+			os.Exit(exitStatus(err))
 		}
-		r.RunFSAFromString(str, os.Stdout)
+	} else if err := prog.Run(context.Background(), os.Stdin, os.Stdout, opts); err != nil {
+		report(err)
+		os.Exit(exitStatus(err))
+	}
+	os.Exit(status)
+}
+
+// report writes err to stderr. exit N is not an error worth printing.
+func report(err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			report(err)
+		}
+		return
+	}
+	var exitErr *runner.ExitError
+	var runtimeErr *runner.RuntimeError
+	if errors.As(err, &exitErr) {
+		return
+	} else if errors.As(err, &runtimeErr) {
+		fmt.Fprintln(os.Stderr, err)
+	} else {
+		fmt.Fprintln(os.Stderr, "ted:", err)
 	}
 }
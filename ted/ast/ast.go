@@ -2,11 +2,29 @@ package ast
 
 import (
 	"bytes"
+
+	"github.com/ahalbert/ted/ted/token"
 )
 
 // The base Node interface
 type Node interface {
 	String() string
+	Pos() token.Token // the token used to locate the node in error messages
+	Extent() Span     // the source the node was parsed from, underlined in error messages
+}
+
+// Span is the source a node was parsed from, from its first token to its
+// last. It is empty for nodes made while running, such as values.
+type Span struct {
+	First token.Token
+	Last  token.Token
+}
+
+func (s *Span) Extent() Span { return *s }
+
+// SetExtent is called by the parser once it has read the node's last token.
+func (s *Span) SetExtent(first token.Token, last token.Token) {
+	s.First, s.Last = first, last
 }
 
 // All statement nodes implement this
@@ -34,11 +52,14 @@ func (fsa *FSA) String() string {
 }
 
 type StateStatement struct {
+	Span
+	Token     token.Token // the label, or the first token of an anonymous state
 	StateName string
 	Action    Action
 }
 
-func (ss *StateStatement) statementNode() {}
+func (ss *StateStatement) statementNode()   {}
+func (ss *StateStatement) Pos() token.Token { return ss.Token }
 func (ss *StateStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ss.StateName + ":" + ss.Action.String())
@@ -46,11 +67,14 @@ func (ss *StateStatement) String() string {
 }
 
 type FunctionStatement struct {
+	Span
+	Token    token.Token // the function name
 	Name     string
 	Function Expression
 }
 
-func (fs *FunctionStatement) statementNode() {}
+func (fs *FunctionStatement) statementNode()   {}
+func (fs *FunctionStatement) Pos() token.Token { return fs.Token }
 func (fs *FunctionStatement) String() string {
 	var out bytes.Buffer
 
@@ -61,9 +85,12 @@ func (fs *FunctionStatement) String() string {
 }
 
 type ActionBlock struct {
+	Span
+	Token   token.Token // the { token
 	Actions []Action
 }
 
+func (ab *ActionBlock) Pos() token.Token { return ab.Token }
 func (ab *ActionBlock) String() string {
 	var out bytes.Buffer
 	out.WriteString("{ ")
@@ -75,10 +102,13 @@ func (ab *ActionBlock) String() string {
 }
 
 type RegexAction struct {
+	Span
+	Token  token.Token
 	Rule   string
 	Action Action
 }
 
+func (ra *RegexAction) Pos() token.Token { return ra.Token }
 func (ra *RegexAction) String() string {
 	var out bytes.Buffer
 	out.WriteString("/" + ra.Rule + "/ " + " :: " + (ra.Action).String())
@@ -86,9 +116,12 @@ func (ra *RegexAction) String() string {
 }
 
 type GotoAction struct {
+	Span
+	Token  token.Token // the target, or the -> token if there is none
 	Target string
 }
 
+func (ga *GotoAction) Pos() token.Token { return ga.Token }
 func (ga *GotoAction) String() string {
 	var out bytes.Buffer
 	out.WriteString("goto: " + ga.Target)
@@ -96,8 +129,11 @@ func (ga *GotoAction) String() string {
 }
 
 type ResetAction struct {
+	Span
+	Token token.Token
 }
 
+func (ra *ResetAction) Pos() token.Token { return ra.Token }
 func (ra *ResetAction) String() string {
 	var out bytes.Buffer
 	out.WriteString("reset")
@@ -105,10 +141,13 @@ func (ra *ResetAction) String() string {
 }
 
 type DoSedAction struct {
+	Span
+	Token    token.Token
 	Variable string
 	Command  string
 }
 
+func (da *DoSedAction) Pos() token.Token { return da.Token }
 func (da *DoSedAction) String() string {
 	var out bytes.Buffer
 	out.WriteString("sed '" + da.Command + "' using var '" + da.Variable + "'")
@@ -116,11 +155,14 @@ func (da *DoSedAction) String() string {
 }
 
 type DoUntilSedAction struct {
+	Span
+	Token    token.Token
 	Variable string
 	Command  string
 	Action   Action
 }
 
+func (da *DoUntilSedAction) Pos() token.Token { return da.Token }
 func (da *DoUntilSedAction) String() string {
 	var out bytes.Buffer
 	out.WriteString("sed '" + da.Command + "' using var '" + da.Variable + "'")
@@ -129,9 +171,12 @@ func (da *DoUntilSedAction) String() string {
 }
 
 type PrintAction struct {
+	Span
+	Token      token.Token
 	Expression Expression
 }
 
+func (pa *PrintAction) Pos() token.Token { return pa.Token }
 func (pa *PrintAction) String() string {
 	var out bytes.Buffer
 	out.WriteString("print '" + pa.Expression.String() + "'")
@@ -139,9 +184,12 @@ func (pa *PrintAction) String() string {
 }
 
 type PrintLnAction struct {
+	Span
+	Token      token.Token
 	Expression Expression
 }
 
+func (pa *PrintLnAction) Pos() token.Token { return pa.Token }
 func (pa *PrintLnAction) String() string {
 	var out bytes.Buffer
 	out.WriteString("println '" + pa.Expression.String() + "'")
@@ -149,10 +197,13 @@ func (pa *PrintLnAction) String() string {
 }
 
 type StartStopCaptureAction struct {
+	Span
+	Token    token.Token
 	Command  string
 	Variable string
 }
 
+func (sscp *StartStopCaptureAction) Pos() token.Token { return sscp.Token }
 func (sscp *StartStopCaptureAction) String() string {
 	var out bytes.Buffer
 	out.WriteString(sscp.Command + " capture into:" + sscp.Variable)
@@ -160,9 +211,12 @@ func (sscp *StartStopCaptureAction) String() string {
 }
 
 type CaptureAction struct {
+	Span
+	Token    token.Token
 	Variable string
 }
 
+func (ca *CaptureAction) Pos() token.Token { return ca.Token }
 func (ca *CaptureAction) String() string {
 	var out bytes.Buffer
 	out.WriteString("temp capture into:" + ca.Variable)
@@ -170,9 +224,12 @@ func (ca *CaptureAction) String() string {
 }
 
 type ClearAction struct {
+	Span
+	Token    token.Token
 	Variable string
 }
 
+func (ca *ClearAction) Pos() token.Token { return ca.Token }
 func (ca *ClearAction) String() string {
 	var out bytes.Buffer
 	out.WriteString("clear:" + ca.Variable)
@@ -180,37 +237,162 @@ func (ca *ClearAction) String() string {
 }
 
 type AssignAction struct {
+	Span
+	Token      token.Token // the let token
 	Target     string
+	Index      Expression // the key or position in Target to set, nil to set Target itself
 	Expression Expression
 }
 
+func (aa *AssignAction) Pos() token.Token { return aa.Token }
 func (aa *AssignAction) String() string {
 	var out bytes.Buffer
-	out.WriteString("set:'" + aa.Target + "'= ")
+	out.WriteString("set:'" + aa.Target)
+	if aa.Index != nil {
+		out.WriteString("[" + aa.Index.String() + "]")
+	}
+	out.WriteString("'= ")
 	out.WriteString("'" + aa.Expression.String() + "'")
 	return out.String()
 }
 
 type MoveHeadAction struct {
+	Span
+	Token   token.Token
 	Command string
 	Regex   string
+	Count   Expression // records to move by, or the record to seek to
+	ToEnd   bool       // seek end
+	Mark    string     // rewind to NAME or goto mark NAME
 }
 
+func (ha *MoveHeadAction) Pos() token.Token { return ha.Token }
 func (ha *MoveHeadAction) String() string {
 	var out bytes.Buffer
 	out.WriteString(ha.Command + " head")
 	if ha.Regex != "" {
 		out.WriteString(" to /" + ha.Regex + "/")
+	} else if ha.Mark != "" {
+		out.WriteString(" to mark " + ha.Mark)
+	} else if ha.ToEnd {
+		out.WriteString(" to end")
+	} else if ha.Count != nil && ha.Command == "seek" {
+		out.WriteString(" to record '" + ha.Count.String() + "'")
+	} else if ha.Count != nil {
+		out.WriteString(" by '" + ha.Count.String() + "'")
 	}
 	return out.String()
 }
 
+type MarkAction struct {
+	Span
+	Token token.Token
+	Name  string
+}
+
+func (ma *MarkAction) Pos() token.Token { return ma.Token }
+func (ma *MarkAction) String() string {
+	return "mark " + ma.Name
+}
+
+// EditTapeAction changes the records on the tape, rather than the output.
+// Command is "write", "insert" or "delete"; After is only used by insert.
+type EditTapeAction struct {
+	Span
+	Token      token.Token
+	Command    string
+	After      bool
+	Expression Expression
+}
+
+func (ea *EditTapeAction) Pos() token.Token { return ea.Token }
+func (ea *EditTapeAction) String() string {
+	var out bytes.Buffer
+	out.WriteString(ea.Command)
+	if ea.Command == "insert" {
+		if ea.After {
+			out.WriteString(" after")
+		} else {
+			out.WriteString(" before")
+		}
+	}
+	if ea.Expression != nil {
+		out.WriteString(" '" + ea.Expression.String() + "'")
+	}
+	return out.String()
+}
+
+// DeleteElementAction removes a key from a map or an element from a list,
+// as opposed to delete on its own, which removes the record from the tape.
+type DeleteElementAction struct {
+	Span
+	Token    token.Token
+	Variable string
+	Index    Expression
+}
+
+func (da *DeleteElementAction) Pos() token.Token { return da.Token }
+func (da *DeleteElementAction) String() string {
+	return "delete " + da.Variable + "[" + da.Index.String() + "]"
+}
+
+// ForAction runs Action once for each key of a map, element of a list or
+// record of a string, with Variable set to it.
+type ForAction struct {
+	Span
+	Token      token.Token
+	Variable   string
+	Collection Expression
+	Action     Action
+}
+
+func (fa *ForAction) Pos() token.Token { return fa.Token }
+func (fa *ForAction) String() string {
+	var out bytes.Buffer
+	out.WriteString("for " + fa.Variable + " in (")
+	out.WriteString(fa.Collection.String())
+	out.WriteString(") do:")
+	out.WriteString(fa.Action.String())
+	return out.String()
+}
+
+type WhileAction struct {
+	Span
+	Token     token.Token
+	Condition Expression
+	Action    Action
+}
+
+func (wa *WhileAction) Pos() token.Token { return wa.Token }
+func (wa *WhileAction) String() string {
+	var out bytes.Buffer
+	out.WriteString("while (")
+	out.WriteString(wa.Condition.String())
+	out.WriteString(") do:")
+	out.WriteString(wa.Action.String())
+	return out.String()
+}
+
+// LoopControlAction is break or continue, which stop the innermost loop,
+// or the rest of its current iteration.
+type LoopControlAction struct {
+	Span
+	Token   token.Token
+	Command string
+}
+
+func (la *LoopControlAction) Pos() token.Token { return la.Token }
+func (la *LoopControlAction) String() string   { return la.Command }
+
 type IfAction struct {
+	Span
+	Token       token.Token
 	Condition   Expression
 	Consequence Action
 	Alternative Action
 }
 
+func (ia *IfAction) Pos() token.Token { return ia.Token }
 func (ia *IfAction) String() string {
 	var out bytes.Buffer
 
@@ -231,6 +413,50 @@ type ExpressionAction struct {
 	Expression Expression
 }
 
+func (ea *ExpressionAction) Pos() token.Token {
+	if ea.Expression == nil {
+		return token.Token{}
+	}
+	return ea.Expression.Pos()
+}
+func (ea *ExpressionAction) Extent() Span {
+	if ea.Expression == nil {
+		return Span{}
+	}
+	return ea.Expression.Extent()
+}
 func (ea *ExpressionAction) String() string {
 	return ea.Expression.String()
 }
+
+type ReturnAction struct {
+	Span
+	Token      token.Token
+	Expression Expression
+}
+
+func (ra *ReturnAction) Pos() token.Token { return ra.Token }
+func (ra *ReturnAction) String() string {
+	var out bytes.Buffer
+	out.WriteString("return")
+	if ra.Expression != nil {
+		out.WriteString(" '" + ra.Expression.String() + "'")
+	}
+	return out.String()
+}
+
+type ExitAction struct {
+	Span
+	Token      token.Token
+	Expression Expression
+}
+
+func (ea *ExitAction) Pos() token.Token { return ea.Token }
+func (ea *ExitAction) String() string {
+	var out bytes.Buffer
+	out.WriteString("exit")
+	if ea.Expression != nil {
+		out.WriteString(" '" + ea.Expression.String() + "'")
+	}
+	return out.String()
+}
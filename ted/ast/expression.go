@@ -2,8 +2,12 @@ package ast
 
 import (
 	"bytes"
+	"fmt"
+	"math"
 	"strconv"
 	"strings"
+
+	"github.com/ahalbert/ted/ted/token"
 )
 
 type Expression interface {
@@ -12,24 +16,44 @@ type Expression interface {
 }
 
 type Identifier struct {
+	Span
+	Token token.Token
 	Value string
 }
 
-func (i *Identifier) expressionNode() {}
-func (i *Identifier) String() string  { return i.Value }
+func (i *Identifier) expressionNode()  {}
+func (i *Identifier) Pos() token.Token { return i.Token }
+func (i *Identifier) String() string   { return i.Value }
 
 type StringLiteral struct {
+	Span
+	Token token.Token
+	Value string
+}
+
+func (sl *StringLiteral) expressionNode()  {}
+func (sl *StringLiteral) Pos() token.Token { return sl.Token }
+func (sl *StringLiteral) String() string   { return sl.Value }
+
+// RegexLiteral is a /regex/ on the right of ~ or !~.
+type RegexLiteral struct {
+	Span
+	Token token.Token
 	Value string
 }
 
-func (sl *StringLiteral) expressionNode() {}
-func (sl *StringLiteral) String() string  { return sl.Value }
+func (rl *RegexLiteral) expressionNode()  {}
+func (rl *RegexLiteral) Pos() token.Token { return rl.Token }
+func (rl *RegexLiteral) String() string   { return "/" + rl.Value + "/" }
 
 type Boolean struct {
+	Span
+	Token token.Token
 	Value bool
 }
 
-func (b *Boolean) expressionNode() {}
+func (b *Boolean) expressionNode()  {}
+func (b *Boolean) Pos() token.Token { return b.Token }
 func (b *Boolean) String() string {
 	if b.Value {
 		return "true"
@@ -38,18 +62,121 @@ func (b *Boolean) String() string {
 }
 
 type IntegerLiteral struct {
+	Span
+	Token token.Token
 	Value int
 }
 
-func (il *IntegerLiteral) expressionNode() {}
-func (il *IntegerLiteral) String() string  { return strconv.Itoa(il.Value) }
+func (il *IntegerLiteral) expressionNode()  {}
+func (il *IntegerLiteral) Pos() token.Token { return il.Token }
+func (il *IntegerLiteral) String() string   { return strconv.Itoa(il.Value) }
+
+// FloatLiteral is a number that may not be whole, such as 12.5. A whole
+// number is written without a fractional part, and anything else with
+// Format, or exactly if Format is empty.
+type FloatLiteral struct {
+	Span
+	Token  token.Token
+	Value  float64
+	Format string
+}
+
+func (fl *FloatLiteral) expressionNode()  {}
+func (fl *FloatLiteral) Pos() token.Token { return fl.Token }
+func (fl *FloatLiteral) String() string {
+	if fl.Value == math.Trunc(fl.Value) && math.Abs(fl.Value) < 1e15 {
+		return strconv.FormatFloat(fl.Value, 'f', 0, 64)
+	}
+	if fl.Format != "" {
+		return fmt.Sprintf(fl.Format, fl.Value)
+	}
+	return strconv.FormatFloat(fl.Value, 'f', -1, 64)
+}
+
+// ListLiteral is a list written as [a, b, c]. It is also the value of a
+// list, with its elements evaluated.
+type ListLiteral struct {
+	Span
+	Token    token.Token // the [ token
+	Elements []Expression
+}
+
+func (ll *ListLiteral) expressionNode()  {}
+func (ll *ListLiteral) Pos() token.Token { return ll.Token }
+func (ll *ListLiteral) String() string {
+	elements := []string{}
+	for _, e := range ll.Elements {
+		elements = append(elements, e.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// MapLiteral is a map written as [k: v, ...], or [:] if it is empty.
+type MapLiteral struct {
+	Span
+	Token  token.Token // the [ token
+	Keys   []Expression
+	Values []Expression
+}
+
+func (ml *MapLiteral) expressionNode()  {}
+func (ml *MapLiteral) Pos() token.Token { return ml.Token }
+func (ml *MapLiteral) String() string {
+	if len(ml.Keys) == 0 {
+		return "[:]"
+	}
+	pairs := []string{}
+	for i, k := range ml.Keys {
+		pairs = append(pairs, k.String()+": "+ml.Values[i].String())
+	}
+	return "[" + strings.Join(pairs, ", ") + "]"
+}
+
+// Map is the value of a map, made by evaluating a MapLiteral or by
+// assigning to a key of a variable that isn't set. Keys are kept in the
+// order they were added.
+type Map struct {
+	Span
+	Token  token.Token
+	Keys   []string
+	Values map[string]Expression
+}
+
+func (m *Map) expressionNode()  {}
+func (m *Map) Pos() token.Token { return m.Token }
+func (m *Map) String() string {
+	if len(m.Keys) == 0 {
+		return "[:]"
+	}
+	pairs := []string{}
+	for _, k := range m.Keys {
+		pairs = append(pairs, k+": "+m.Values[k].String())
+	}
+	return "[" + strings.Join(pairs, ", ") + "]"
+}
+
+type IndexExpression struct {
+	Span
+	Token token.Token // the [ token
+	Left  Expression
+	Index Expression
+}
+
+func (ie *IndexExpression) expressionNode()  {}
+func (ie *IndexExpression) Pos() token.Token { return ie.Left.Pos() }
+func (ie *IndexExpression) String() string {
+	return ie.Left.String() + "[" + ie.Index.String() + "]"
+}
 
 type PrefixExpression struct {
+	Span
+	Token    token.Token // the prefix operator
 	Operator string
 	Right    Expression
 }
 
-func (pe *PrefixExpression) expressionNode() {}
+func (pe *PrefixExpression) expressionNode()  {}
+func (pe *PrefixExpression) Pos() token.Token { return pe.Token }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -62,12 +189,15 @@ func (pe *PrefixExpression) String() string {
 }
 
 type InfixExpression struct {
+	Span
+	Token    token.Token // the infix operator
 	Left     Expression
 	Operator string
 	Right    Expression
 }
 
-func (oe *InfixExpression) expressionNode() {}
+func (oe *InfixExpression) expressionNode()  {}
+func (oe *InfixExpression) Pos() token.Token { return oe.Token }
 func (oe *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -81,11 +211,14 @@ func (oe *InfixExpression) String() string {
 }
 
 type FunctionLiteral struct {
+	Span
+	Token      token.Token
 	Parameters []*Identifier
 	Body       Action
 }
 
-func (fl *FunctionLiteral) expressionNode() {}
+func (fl *FunctionLiteral) expressionNode()  {}
+func (fl *FunctionLiteral) Pos() token.Token { return fl.Token }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -103,11 +236,14 @@ func (fl *FunctionLiteral) String() string {
 }
 
 type CallExpression struct {
-	Function  Expression // Identifier or FunctionLiteral
+	Span
+	Token     token.Token // the ( token
+	Function  Expression  // Identifier or FunctionLiteral
 	Arguments []Expression
 }
 
-func (ce *CallExpression) expressionNode() {}
+func (ce *CallExpression) expressionNode()  {}
+func (ce *CallExpression) Pos() token.Token { return ce.Function.Pos() }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -0,0 +1,593 @@
+package checker
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/ahalbert/ted/ted/ast"
+	"github.com/ahalbert/ted/ted/runner"
+	"github.com/ahalbert/ted/ted/token"
+)
+
+const (
+	Error   = "error"
+	Warning = "warning"
+)
+
+type Diagnostic struct {
+	Severity string
+	Message  string
+	Token    token.Token
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s at line %d col %d: %s", d.Severity, d.Token.LineNum, d.Token.Position, d.Message)
+}
+
+// predefined are the variables the runner sets before any action runs.
+var predefined = []string{"$_", "$@", "$RS", "$RSMODE", "$RT", "$PRINTMODE", "$NULL", "$FILENAME", "$FNR", "$NR", "$OFFSET", "$LEN",
+	"$FS", "$OFS", "$FSMODE", "$NF", "$FORMAT", "$HEADER", "$LOOPLIMIT", "$OFMT"}
+
+// captureGroup matches $0..$N, set by regexes, and $F1..$FN and $.name,
+// split from $_.
+var captureGroup = regexp.MustCompile(`^\$([0-9]+|F[1-9][0-9]*|\..+)$`)
+
+type varSet map[string]bool
+
+func (vs varSet) copy() varSet {
+	out := make(varSet, len(vs))
+	for k := range vs {
+		out[k] = true
+	}
+	return out
+}
+
+// addAll adds every variable in other to vs and reports whether vs grew.
+func (vs varSet) addAll(other varSet) bool {
+	grew := false
+	for k := range other {
+		if !vs[k] {
+			vs[k] = true
+			grew = true
+		}
+	}
+	return grew
+}
+
+type checker struct {
+	runner      *runner.Runner
+	declared    map[string]token.Token
+	functions   map[string]*ast.FunctionStatement
+	successors  map[string][]string
+	diagnostics []Diagnostic
+	reported    map[Diagnostic]bool
+}
+
+// Check reports problems in fsa that would otherwise only surface while it
+// runs: transitions to undefined states, unreachable and dead-end states,
+// variables read before anything could set them, and calls to undefined
+// functions. vars are the variables that will be supplied on the command
+// line.
+func Check(fsa ast.FSA, vars map[string]string) []Diagnostic {
+	runnerVars := make(map[string]string, len(vars))
+	for k, v := range vars {
+		runnerVars[k] = v
+	}
+	c := &checker{
+		runner:     runner.NewRunner(fsa, runnerVars),
+		declared:   make(map[string]token.Token),
+		functions:  make(map[string]*ast.FunctionStatement),
+		successors: make(map[string][]string),
+		reported:   make(map[Diagnostic]bool),
+	}
+	for _, statement := range fsa.Statements {
+		switch statement.(type) {
+		case *ast.StateStatement:
+			stmt := statement.(*ast.StateStatement)
+			if _, ok := c.declared[stmt.StateName]; !ok {
+				c.declared[stmt.StateName] = stmt.Token
+			}
+		case *ast.FunctionStatement:
+			stmt := statement.(*ast.FunctionStatement)
+			c.functions[stmt.Name] = stmt
+		}
+	}
+
+	c.checkTransitions()
+	c.checkReachability()
+	c.checkFunctionCalls()
+	c.checkMarks()
+	c.checkVariables(vars)
+
+	sort.SliceStable(c.diagnostics, func(i, j int) bool {
+		a, b := c.diagnostics[i].Token, c.diagnostics[j].Token
+		if a.LineNum != b.LineNum {
+			return a.LineNum < b.LineNum
+		}
+		return a.Position < b.Position
+	})
+	return c.diagnostics
+}
+
+func (c *checker) report(severity string, tok token.Token, format string, args ...any) {
+	d := Diagnostic{Severity: severity, Message: fmt.Sprintf(format, args...), Token: tok}
+	if c.reported[d] {
+		return
+	}
+	c.reported[d] = true
+	c.diagnostics = append(c.diagnostics, d)
+}
+
+func (c *checker) isState(name string) bool {
+	_, ok := c.declared[name]
+	return ok || name == "0"
+}
+
+// walkActions calls fn on action and every action nested inside it.
+func walkActions(action ast.Action, fn func(ast.Action)) {
+	fn(action)
+	switch action.(type) {
+	case *ast.ActionBlock:
+		for _, a := range action.(*ast.ActionBlock).Actions {
+			walkActions(a, fn)
+		}
+	case *ast.RegexAction:
+		walkActions(action.(*ast.RegexAction).Action, fn)
+	case *ast.DoUntilSedAction:
+		walkActions(action.(*ast.DoUntilSedAction).Action, fn)
+	case *ast.IfAction:
+		ia := action.(*ast.IfAction)
+		walkActions(ia.Consequence, fn)
+		if ia.Alternative != nil {
+			walkActions(ia.Alternative, fn)
+		}
+	case *ast.ForAction:
+		walkActions(action.(*ast.ForAction).Action, fn)
+	case *ast.WhileAction:
+		walkActions(action.(*ast.WhileAction).Action, fn)
+	}
+}
+
+// expressions returns the expressions an action evaluates directly, not
+// counting those in nested actions.
+func expressions(action ast.Action) []ast.Expression {
+	switch action.(type) {
+	case *ast.PrintAction:
+		return []ast.Expression{action.(*ast.PrintAction).Expression}
+	case *ast.PrintLnAction:
+		return []ast.Expression{action.(*ast.PrintLnAction).Expression}
+	case *ast.AssignAction:
+		aa := action.(*ast.AssignAction)
+		if aa.Index != nil {
+			return []ast.Expression{aa.Index, aa.Expression}
+		}
+		return []ast.Expression{aa.Expression}
+	case *ast.DeleteElementAction:
+		return []ast.Expression{action.(*ast.DeleteElementAction).Index}
+	case *ast.ForAction:
+		return []ast.Expression{action.(*ast.ForAction).Collection}
+	case *ast.WhileAction:
+		return []ast.Expression{action.(*ast.WhileAction).Condition}
+	case *ast.IfAction:
+		return []ast.Expression{action.(*ast.IfAction).Condition}
+	case *ast.ExpressionAction:
+		return []ast.Expression{action.(*ast.ExpressionAction).Expression}
+	case *ast.ReturnAction:
+		return []ast.Expression{action.(*ast.ReturnAction).Expression}
+	case *ast.ExitAction:
+		return []ast.Expression{action.(*ast.ExitAction).Expression}
+	case *ast.EditTapeAction:
+		return []ast.Expression{action.(*ast.EditTapeAction).Expression}
+	case *ast.MoveHeadAction:
+		return []ast.Expression{action.(*ast.MoveHeadAction).Count}
+	}
+	return nil
+}
+
+// walkExpression calls fn on expression and every sub-expression. The
+// function being called is not itself treated as a sub-expression.
+func walkExpression(expression ast.Expression, fn func(ast.Expression)) {
+	if expression == nil {
+		return
+	}
+	fn(expression)
+	switch expression.(type) {
+	case *ast.PrefixExpression:
+		walkExpression(expression.(*ast.PrefixExpression).Right, fn)
+	case *ast.InfixExpression:
+		ie := expression.(*ast.InfixExpression)
+		walkExpression(ie.Left, fn)
+		walkExpression(ie.Right, fn)
+	case *ast.CallExpression:
+		for _, arg := range expression.(*ast.CallExpression).Arguments {
+			walkExpression(arg, fn)
+		}
+	case *ast.IndexExpression:
+		ie := expression.(*ast.IndexExpression)
+		walkExpression(ie.Left, fn)
+		walkExpression(ie.Index, fn)
+	case *ast.ListLiteral:
+		for _, e := range expression.(*ast.ListLiteral).Elements {
+			walkExpression(e, fn)
+		}
+	case *ast.MapLiteral:
+		ml := expression.(*ast.MapLiteral)
+		for i := range ml.Keys {
+			walkExpression(ml.Keys[i], fn)
+			walkExpression(ml.Values[i], fn)
+		}
+	}
+}
+
+// containers returns the variables expr uses as a list or map: indexed,
+// tested with in, or passed to len. These read as an empty map before they
+// are set, so reading them early isn't a mistake.
+func containers(expr ast.Expression) map[*ast.Identifier]bool {
+	out := make(map[*ast.Identifier]bool)
+	add := func(e ast.Expression) {
+		if ident, ok := e.(*ast.Identifier); ok {
+			out[ident] = true
+		}
+	}
+	walkExpression(expr, func(e ast.Expression) {
+		switch e.(type) {
+		case *ast.IndexExpression:
+			add(e.(*ast.IndexExpression).Left)
+		case *ast.InfixExpression:
+			if ie := e.(*ast.InfixExpression); ie.Operator == "in" {
+				add(ie.Right)
+			}
+		case *ast.CallExpression:
+			ce := e.(*ast.CallExpression)
+			if ident, ok := ce.Function.(*ast.Identifier); ok && ident.Value == "len" && len(ce.Arguments) == 1 {
+				add(ce.Arguments[0])
+			}
+		}
+	})
+	return out
+}
+
+// calledFunctionsIn returns the user functions called anywhere inside expr.
+func (c *checker) calledFunctionsIn(expr ast.Expression) []*ast.FunctionStatement {
+	called := []*ast.FunctionStatement{}
+	walkExpression(expr, func(e ast.Expression) {
+		ce, ok := e.(*ast.CallExpression)
+		if !ok {
+			return
+		}
+		ident, ok := ce.Function.(*ast.Identifier)
+		if !ok {
+			return
+		}
+		if fn, ok := c.functions[ident.Value]; ok {
+			called = append(called, fn)
+		}
+	})
+	return called
+}
+
+// calledFunctions returns the user functions called anywhere inside action.
+func (c *checker) calledFunctions(action ast.Action) []*ast.FunctionStatement {
+	called := []*ast.FunctionStatement{}
+	walkActions(action, func(a ast.Action) {
+		for _, expr := range expressions(a) {
+			called = append(called, c.calledFunctionsIn(expr)...)
+		}
+	})
+	return called
+}
+
+func (c *checker) functionBody(fn *ast.FunctionStatement) ast.Action {
+	lit, ok := fn.Function.(*ast.FunctionLiteral)
+	if !ok {
+		return nil
+	}
+	return lit.Body
+}
+
+// stateActions returns the actions of state together with the bodies of
+// every function they may call, directly or indirectly.
+func (c *checker) stateActions(state *runner.State) []ast.Action {
+	actions := append([]ast.Action{}, state.Actions...)
+	seen := make(map[string]bool)
+	for i := 0; i < len(actions); i++ {
+		for _, fn := range c.calledFunctions(actions[i]) {
+			if !seen[fn.Name] {
+				seen[fn.Name] = true
+				actions = append(actions, c.functionBody(fn))
+			}
+		}
+	}
+	return actions
+}
+
+func (c *checker) checkTransitions() {
+	for _, name := range c.runner.StateNames {
+		state := c.runner.States[name]
+		for _, action := range c.stateActions(state) {
+			walkActions(action, func(a ast.Action) {
+				switch a.(type) {
+				case *ast.GotoAction:
+					ga := a.(*ast.GotoAction)
+					target := ga.Target
+					if target == "" {
+						target = state.NextState
+					} else if !c.isState(target) {
+						c.report(Error, ga.Token, "transition to undefined state %q", target)
+					}
+					c.successors[name] = append(c.successors[name], target)
+				case *ast.ResetAction:
+					c.successors[name] = append(c.successors[name], c.runner.StartState)
+				}
+			})
+		}
+	}
+	for _, statement := range c.functions {
+		walkActions(c.functionBody(statement), func(a ast.Action) {
+			ga, ok := a.(*ast.GotoAction)
+			if ok && ga.Target != "" && !c.isState(ga.Target) {
+				c.report(Error, ga.Token, "transition to undefined state %q", ga.Target)
+			}
+		})
+	}
+}
+
+func (c *checker) checkReachability() {
+	start := c.runner.StartState
+	reached := map[string]bool{start: true}
+	queue := []string{start}
+	for _, special := range []string{"BEGIN", "BEGINFILE", "ENDFILE", "ALL"} {
+		queue = append(queue, c.successors[special]...)
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		reached[name] = true
+		for _, next := range c.successors[name] {
+			if !reached[next] {
+				reached[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	for _, name := range c.runner.StateNames {
+		if runner.IsSpecialState(name) || name == "0" {
+			continue
+		}
+		if !reached[name] {
+			c.report(Warning, c.declared[name], "state %q is unreachable from the start state %q", name, start)
+		}
+		if len(c.successors[name]) == 0 && len(c.successors["ALL"]) == 0 {
+			c.report(Warning, c.declared[name], "state %q has no outgoing transitions", name)
+		}
+	}
+}
+
+func (c *checker) checkFunctionCalls() {
+	check := func(action ast.Action) {
+		walkActions(action, func(a ast.Action) {
+			for _, expr := range expressions(a) {
+				walkExpression(expr, func(e ast.Expression) {
+					ce, ok := e.(*ast.CallExpression)
+					if !ok {
+						return
+					}
+					ident, ok := ce.Function.(*ast.Identifier)
+					if ok && c.functions[ident.Value] == nil && !runner.IsBuiltin(ident.Value) {
+						c.report(Error, ident.Token, "call to undefined function %q", ident.Value)
+					}
+				})
+			}
+		})
+	}
+	for _, name := range c.runner.StateNames {
+		for _, action := range c.runner.States[name].Actions {
+			check(action)
+		}
+	}
+	for _, fn := range c.functions {
+		check(c.functionBody(fn))
+	}
+}
+
+func (c *checker) checkMarks() {
+	var actions []ast.Action
+	for _, name := range c.runner.StateNames {
+		actions = append(actions, c.runner.States[name].Actions...)
+	}
+	for _, fn := range c.functions {
+		actions = append(actions, c.functionBody(fn))
+	}
+
+	set := make(map[string]bool)
+	for _, action := range actions {
+		walkActions(action, func(a ast.Action) {
+			if ma, ok := a.(*ast.MarkAction); ok {
+				set[ma.Name] = true
+			}
+		})
+	}
+	for _, action := range actions {
+		walkActions(action, func(a ast.Action) {
+			if ha, ok := a.(*ast.MoveHeadAction); ok && ha.Mark != "" && !set[ha.Mark] {
+				c.report(Error, ha.Token, "move to mark %q, which is never set", ha.Mark)
+			}
+		})
+	}
+}
+
+// assigns returns the variable action itself assigns to, if any.
+func assigns(action ast.Action) string {
+	switch action.(type) {
+	case *ast.AssignAction:
+		return action.(*ast.AssignAction).Target
+	case *ast.CaptureAction:
+		return action.(*ast.CaptureAction).Variable
+	case *ast.StartStopCaptureAction:
+		return action.(*ast.StartStopCaptureAction).Variable
+	case *ast.ClearAction:
+		return action.(*ast.ClearAction).Variable
+	case *ast.ForAction:
+		return action.(*ast.ForAction).Variable
+	}
+	return ""
+}
+
+// sets returns the variables action or any action nested in it may assign
+// to, other than the given parameters.
+func (c *checker) sets(action ast.Action, params varSet) varSet {
+	vars := make(varSet)
+	walkActions(action, func(a ast.Action) {
+		if name := assigns(a); name != "" && !params[name] {
+			vars[name] = true
+		}
+	})
+	return vars
+}
+
+func (c *checker) functionParams(fn *ast.FunctionStatement) varSet {
+	params := make(varSet)
+	if lit, ok := fn.Function.(*ast.FunctionLiteral); ok {
+		for _, p := range lit.Parameters {
+			params[p.Value] = true
+		}
+	}
+	return params
+}
+
+// stateSets returns the variables running state may assign to, including
+// globals assigned by the functions it calls.
+func (c *checker) stateSets(state *runner.State) varSet {
+	vars := make(varSet)
+	for _, action := range state.Actions {
+		vars.addAll(c.sets(action, nil))
+	}
+	for _, fn := range c.calledFunctions(&ast.ActionBlock{Actions: state.Actions}) {
+		vars.addAll(c.sets(c.functionBody(fn), c.functionParams(fn)))
+	}
+	return vars
+}
+
+// checkVariables warns about identifiers read before any action could have
+// assigned them. Availability is propagated along transitions until it
+// stops changing; ALL runs alongside every state so it both feeds and is
+// fed by all of them.
+func (c *checker) checkVariables(vars map[string]string) {
+	initial := make(varSet)
+	for _, name := range predefined {
+		initial[name] = true
+	}
+	for name := range vars {
+		initial[name] = true
+	}
+
+	preds := make(map[string][]string)
+	for from, targets := range c.successors {
+		for _, to := range targets {
+			preds[to] = append(preds[to], from)
+		}
+	}
+	for _, name := range c.runner.StateNames {
+		if name == "BEGIN" {
+			continue
+		}
+		preds[name] = append(preds[name], "BEGIN")
+		if name == "END" {
+			continue
+		}
+		if name != "ALL" {
+			preds[name] = append(preds[name], "ALL")
+			preds["ALL"] = append(preds["ALL"], name)
+		}
+		//BEGINFILE and ENDFILE run between files, so any state can come before or after them.
+		for _, special := range []string{"BEGINFILE", "ENDFILE"} {
+			if name != special {
+				preds[name] = append(preds[name], special)
+				preds[special] = append(preds[special], name)
+			}
+		}
+		preds["END"] = append(preds["END"], name)
+	}
+
+	sets := make(map[string]varSet)
+	in := make(map[string]varSet)
+	out := make(map[string]varSet)
+	for name, state := range c.runner.States {
+		sets[name] = c.stateSets(state)
+		in[name] = initial.copy()
+		out[name] = initial.copy()
+		out[name].addAll(sets[name])
+	}
+	for changed := true; changed; {
+		changed = false
+		for name := range c.runner.States {
+			if name == "BEGIN" {
+				continue
+			}
+			for _, pred := range preds[name] {
+				from := out[pred]
+				if pred == "ALL" {
+					//ALL only contributes its own assignments, or every state would see its own.
+					from = sets[pred]
+				}
+				if pred != name && from != nil && in[name].addAll(from) {
+					changed = true
+				}
+			}
+			if out[name].addAll(in[name]) {
+				changed = true
+			}
+		}
+	}
+
+	for _, name := range c.runner.StateNames {
+		available := in[name].copy()
+		for _, action := range c.runner.States[name].Actions {
+			c.checkReads(action, available)
+		}
+	}
+
+	//Functions can be called from anywhere, so only flag reads of variables nothing ever sets.
+	everywhere := initial.copy()
+	for _, set := range sets {
+		everywhere.addAll(set)
+	}
+	for _, fn := range c.functions {
+		everywhere.addAll(c.sets(c.functionBody(fn), c.functionParams(fn)))
+	}
+	for _, fn := range c.functions {
+		available := everywhere.copy()
+		available.addAll(c.functionParams(fn))
+		c.checkReads(c.functionBody(fn), available)
+	}
+}
+
+// checkReads walks action in execution order, reporting identifiers that
+// are read while not in available and adding assignments as it goes.
+func (c *checker) checkReads(action ast.Action, available varSet) {
+	walkActions(action, func(a ast.Action) {
+		for _, expr := range expressions(a) {
+			lenient := containers(expr)
+			if fa, ok := a.(*ast.ForAction); ok {
+				if ident, ok := fa.Collection.(*ast.Identifier); ok {
+					lenient[ident] = true
+				}
+			}
+			walkExpression(expr, func(e ast.Expression) {
+				ident, ok := e.(*ast.Identifier)
+				if !ok || available[ident.Value] || lenient[ident] || captureGroup.MatchString(ident.Value) {
+					return
+				}
+				c.report(Warning, ident.Token, "variable %q may be read before it is set", ident.Value)
+			})
+			for _, fn := range c.calledFunctionsIn(expr) {
+				available.addAll(c.sets(c.functionBody(fn), c.functionParams(fn)))
+			}
+		}
+		if name := assigns(a); name != "" {
+			available[name] = true
+		}
+	})
+}
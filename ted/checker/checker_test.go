@@ -0,0 +1,83 @@
+package checker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ahalbert/ted/ted/lexer"
+	"github.com/ahalbert/ted/ted/parser"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		program  string
+		expected []string
+	}{
+		{
+			program:  `/foo/ -> /bar/ -> -> 1`,
+			expected: []string{},
+		},
+		{
+			program:  `a: /foo/ -> b_typo b: -> a`,
+			expected: []string{`transition to undefined state "b_typo"`, `state "b" is unreachable from the start state "a"`},
+		},
+		{
+			program:  `a: /foo/ -> b b: print`,
+			expected: []string{`state "b" has no outgoing transitions`},
+		},
+		{
+			program:  `a: { println count let count = 1 -> a }`,
+			expected: []string{`variable "count" may be read before it is set`},
+		},
+		{
+			program:  `BEGIN: let count = 0 a: { println count println $1 println $_ -> a }`,
+			expected: []string{},
+		},
+		{
+			program:  `a: /x/ { mark top -> b } b: /y/ { rewind to top -> a } b: /z/ { goto mark bottom -> a }`,
+			expected: []string{`move to mark "bottom", which is never set`},
+		},
+		{
+			program:  `BEGINFILE: { let first = $FNR -> b } a: { println first println $FILENAME println $NR -> a } b: -> a`,
+			expected: []string{},
+		},
+		{
+			program:  `a: { println $F2 println $NF println $RT let $F1 = $FS -> a }`,
+			expected: []string{},
+		},
+		{
+			program:  `function f(x) { let seen = x return y } a: { println f(1) println seen -> a }`,
+			expected: []string{`variable "y" may be read before it is set`},
+		},
+		{
+			program:  `a: { let counts[$1] = counts[$1] + 1 if $1 in seen println len(total) -> a } END: for k in counts println counts[k]`,
+			expected: []string{},
+		},
+		{
+			program:  `a: { while true { let x = 1 break } for line in $_ println line println x -> a }`,
+			expected: []string{},
+		},
+		{
+			program:  `a: { println seen[key] -> a }`,
+			expected: []string{`variable "key" may be read before it is set`},
+		},
+		{
+			program:  `a: { println g(1) -> a }`,
+			expected: []string{`call to undefined function "g"`},
+		},
+	}
+
+	for i, tt := range tests {
+		fsa, errors := parser.New(lexer.New(tt.program)).ParseFSA()
+		if len(errors) > 0 {
+			t.Fatalf("test[%d] - parser errors: %v", i, errors)
+		}
+		got := []string{}
+		for _, d := range Check(fsa, map[string]string{}) {
+			got = append(got, d.Message)
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("test[%d] - Check(%q) =\n%s\nwant\n%s", i, tt.program, strings.Join(got, "\n"), strings.Join(tt.expected, "\n"))
+		}
+	}
+}
@@ -1,11 +1,53 @@
 package flags
 
-var Flags struct {
+import "strings"
+
+var Flags Args
+
+type Args struct {
 	ProgramFile string   `arg:"-f,--fsa-file" placeholder:"FSAFILE" help:"Finite State Autonoma file to run."`
 	NoPrint     bool     `arg:"-n,--no-print" help:"Do not print lines by default."`
 	Seperator   string   `arg:"-s,--seperator" help:"Record Seperator. Defaults to \\n"`
+	RegexSep    bool     `arg:"--regex-seperator" help:"Treat the record seperator as a regular expression."`
+	FieldSep    string   `arg:"-F,--field-seperator" placeholder:"FS" help:"Field seperator for $F1..$FN. Defaults to runs of whitespace."`
+	Format      string   `arg:"--format" placeholder:"csv|tsv|jsonl" help:"Read records as CSV or TSV rows, or as JSON Lines with values at $.path."`
 	DebugMode   bool     `arg:"--debug" help:"Provides Lexer and Parser information."`
+	Check       bool     `arg:"--check" help:"Report likely mistakes in the program instead of running it."`
+	Graph       string   `arg:"--graph" placeholder:"dot|mermaid" help:"Print the state machine as a diagram instead of running it."`
 	Variables   []string `arg:"--var,separate" placeholder:"key=value" help:"Variable in the format name=value."`
+	PerFile     bool     `arg:"--per-file" help:"Start each input file in the start state instead of where the last file left off."`
+	History     int      `arg:"--history" default:"10000" placeholder:"N" help:"Records kept for rewind when reading from stdin. 0 keeps everything."`
+	LoopLimit   int      `arg:"--loop-limit" default:"1000000" placeholder:"N" help:"Times a while loop may run, or cycles ted may stay paused, before it is an error. 0 for no limit."`
+	InPlace     bool     `arg:"-"`
+	Backup      string   `arg:"-"`
 	Program     string   `arg:"positional" help:"Program to run."`
 	InputFiles  []string `arg:"positional" placeholder:"INPUTFILE" help:"File to use as input."`
 }
+
+func (Args) Epilogue() string {
+	return "In-place editing:\n  -i[SUFFIX], --in-place[=SUFFIX]\n                         Edit the input files in place, keeping a backup with SUFFIX if given."
+}
+
+// ExtractInPlace removes sed style -i[SUFFIX] and --in-place[=SUFFIX] from
+// args and records them in Flags. go-arg can't parse an optional value that
+// is attached to its flag.
+func ExtractInPlace(args []string) []string {
+	rest := []string{}
+	for i, a := range args {
+		switch {
+		case a == "--":
+			return append(rest, args[i:]...)
+		case a == "-i" || a == "--in-place":
+			Flags.InPlace = true
+		case strings.HasPrefix(a, "-i") && !strings.HasPrefix(a, "--"):
+			Flags.InPlace = true
+			Flags.Backup = a[len("-i"):]
+		case strings.HasPrefix(a, "--in-place="):
+			Flags.InPlace = true
+			Flags.Backup = a[len("--in-place="):]
+		default:
+			rest = append(rest, a)
+		}
+	}
+	return rest
+}
@@ -0,0 +1,234 @@
+package graph
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ahalbert/ted/ted/ast"
+	"github.com/ahalbert/ted/ted/runner"
+)
+
+type edgeKind int
+
+const (
+	gotoEdge  edgeKind = iota // an explicit -> target
+	nextEdge                  // a bare -> to the next state in the program
+	resetEdge                 // --> back to the start state
+	startEdge                 // where the machine begins
+)
+
+type edge struct {
+	from  string
+	to    string
+	label string
+	kind  edgeKind
+}
+
+// Graph is the set of states and transitions of a parsed FSA.
+type Graph struct {
+	Start  string
+	States []string
+	edges  []edge
+	seen   map[edge]bool
+}
+
+// New walks the states of r and collects every transition, labelled with
+// the regexes and conditions that guard it.
+func New(r *runner.Runner) *Graph {
+	g := &Graph{Start: r.StartState, seen: make(map[edge]bool)}
+	if g.Start == "" {
+		g.Start = "0"
+	}
+
+	if _, ok := r.States["BEGIN"]; ok {
+		g.States = append(g.States, "BEGIN")
+		g.addEdge(edge{from: "BEGIN", to: g.Start, kind: startEdge})
+	}
+	for _, name := range r.StateNames {
+		if !runner.IsSpecialState(name) {
+			g.States = append(g.States, name)
+		}
+	}
+	for _, name := range []string{"BEGINFILE", "ENDFILE", "ALL", "END"} {
+		if _, ok := r.States[name]; ok {
+			g.States = append(g.States, name)
+		}
+	}
+
+	for _, name := range g.States {
+		state := r.States[name]
+		for _, action := range state.Actions {
+			g.walk(state, action, nil)
+		}
+	}
+
+	//Targets that are never declared, such as the halting state 0, still need a node.
+	for _, e := range g.edges {
+		if !contains(g.States, e.to) {
+			g.States = append(g.States, e.to)
+		}
+	}
+	return g
+}
+
+func (g *Graph) walk(state *runner.State, action ast.Action, guards []string) {
+	label := strings.Join(guards, " && ")
+	switch action.(type) {
+	case *ast.ActionBlock:
+		for _, a := range action.(*ast.ActionBlock).Actions {
+			g.walk(state, a, guards)
+		}
+	case *ast.RegexAction:
+		ra := action.(*ast.RegexAction)
+		g.walk(state, ra.Action, appendGuard(guards, "/"+ra.Rule+"/"))
+	case *ast.DoUntilSedAction:
+		da := action.(*ast.DoUntilSedAction)
+		g.walk(state, da.Action, appendGuard(guards, "changed by "+da.Command))
+	case *ast.IfAction:
+		ia := action.(*ast.IfAction)
+		g.walk(state, ia.Consequence, appendGuard(guards, ia.Condition.String()))
+		if ia.Alternative != nil {
+			g.walk(state, ia.Alternative, appendGuard(guards, "!"+ia.Condition.String()))
+		}
+	case *ast.ForAction:
+		fa := action.(*ast.ForAction)
+		g.walk(state, fa.Action, appendGuard(guards, fa.Variable+" in "+fa.Collection.String()))
+	case *ast.WhileAction:
+		wa := action.(*ast.WhileAction)
+		g.walk(state, wa.Action, appendGuard(guards, wa.Condition.String()))
+	case *ast.GotoAction:
+		ga := action.(*ast.GotoAction)
+		if ga.Target == "" {
+			g.addEdge(edge{from: state.StateName, to: state.NextState, label: label, kind: nextEdge})
+		} else {
+			g.addEdge(edge{from: state.StateName, to: ga.Target, label: label, kind: gotoEdge})
+		}
+	case *ast.ResetAction:
+		g.addEdge(edge{from: state.StateName, to: g.Start, label: label, kind: resetEdge})
+	}
+}
+
+func appendGuard(guards []string, guard string) []string {
+	return append(guards[:len(guards):len(guards)], guard)
+}
+
+func (g *Graph) addEdge(e edge) {
+	if g.seen[e] {
+		return
+	}
+	g.seen[e] = true
+	g.edges = append(g.edges, e)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Write renders g to w as either "dot" or "mermaid".
+func (g *Graph) Write(w io.Writer, format string) error {
+	var out string
+	switch format {
+	case "dot":
+		out = g.Dot()
+	case "mermaid":
+		out = g.Mermaid()
+	default:
+		return fmt.Errorf("unknown graph format %q, expected dot or mermaid", format)
+	}
+	_, err := io.WriteString(w, out)
+	return err
+}
+
+// Dot renders g in the Graphviz dot language.
+func (g *Graph) Dot() string {
+	var out bytes.Buffer
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+
+	out.WriteString("digraph ted {\n")
+	out.WriteString("\trankdir=LR;\n")
+	out.WriteString("\tnode [shape=circle];\n")
+	if !contains(g.States, "BEGIN") {
+		out.WriteString("\t__start [shape=point];\n")
+		out.WriteString("\t__start -> " + quote(g.Start) + ";\n")
+	}
+	for _, name := range g.States {
+		switch {
+		case runner.IsSpecialState(name):
+			out.WriteString("\t" + quote(name) + " [shape=box];\n")
+		case name == "0":
+			out.WriteString("\t" + quote(name) + " [shape=doublecircle];\n")
+		default:
+			out.WriteString("\t" + quote(name) + ";\n")
+		}
+	}
+	for _, e := range g.edges {
+		attrs := []string{}
+		if e.label != "" {
+			attrs = append(attrs, "label="+quote(e.label))
+		}
+		switch e.kind {
+		case resetEdge:
+			attrs = append(attrs, "style=dashed")
+		case startEdge:
+			attrs = append(attrs, "style=dotted")
+		}
+		out.WriteString("\t" + quote(e.from) + " -> " + quote(e.to))
+		if len(attrs) > 0 {
+			out.WriteString(" [" + strings.Join(attrs, ", ") + "]")
+		}
+		out.WriteString(";\n")
+	}
+	out.WriteString("}\n")
+	return out.String()
+}
+
+// Mermaid renders g as a mermaid flowchart.
+func (g *Graph) Mermaid() string {
+	var out bytes.Buffer
+	ids := make(map[string]string)
+	id := func(name string) string {
+		if _, ok := ids[name]; !ok {
+			ids[name] = fmt.Sprintf("s%d", len(ids))
+		}
+		return ids[name]
+	}
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+	}
+
+	out.WriteString("flowchart LR\n")
+	if !contains(g.States, "BEGIN") {
+		out.WriteString("\tstart((\" \")) --> " + id(g.Start) + "\n")
+	}
+	for _, name := range g.States {
+		switch {
+		case runner.IsSpecialState(name):
+			out.WriteString("\t" + id(name) + "[" + quote(name) + "]\n")
+		case name == "0":
+			out.WriteString("\t" + id(name) + "(((" + quote(name) + ")))\n")
+		default:
+			out.WriteString("\t" + id(name) + "((" + quote(name) + "))\n")
+		}
+	}
+	for _, e := range g.edges {
+		arrow := "-->"
+		if e.kind == resetEdge || e.kind == startEdge {
+			arrow = "-.->"
+		}
+		out.WriteString("\t" + id(e.from) + " " + arrow)
+		if e.label != "" {
+			out.WriteString("|" + quote(e.label) + "|")
+		}
+		out.WriteString(" " + id(e.to) + "\n")
+	}
+	return out.String()
+}
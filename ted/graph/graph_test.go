@@ -0,0 +1,60 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/ahalbert/ted/ted/lexer"
+	"github.com/ahalbert/ted/ted/parser"
+	"github.com/ahalbert/ted/ted/runner"
+)
+
+func newGraph(t *testing.T, program string) *Graph {
+	t.Helper()
+	fsa, errors := parser.New(lexer.New(program)).ParseFSA()
+	if len(errors) > 0 {
+		t.Fatal(errors)
+	}
+	return New(runner.NewRunner(fsa, map[string]string{}))
+}
+
+func TestDot(t *testing.T) {
+	g := newGraph(t, `BEGIN: let n = 0
+a: /foo/ -> b
+b: { /bar/ --> if n == 1 -> }
+ALL: /"quit"/ -> 0`)
+
+	expected := `digraph ted {
+	rankdir=LR;
+	node [shape=circle];
+	"BEGIN" [shape=box];
+	"a";
+	"b";
+	"ALL" [shape=box];
+	"0" [shape=doublecircle];
+	"BEGIN" -> "a" [style=dotted];
+	"a" -> "b" [label="/foo/"];
+	"b" -> "a" [label="/bar/", style=dashed];
+	"b" -> "0" [label="(n == 1)"];
+	"ALL" -> "0" [label="/\"quit\"/"];
+}
+`
+	if g.Dot() != expected {
+		t.Errorf("Dot() =\n%s\nwant\n%s", g.Dot(), expected)
+	}
+}
+
+func TestMermaid(t *testing.T) {
+	g := newGraph(t, `/foo/ -> /bar/ -> typo`)
+
+	expected := `flowchart LR
+	start((" ")) --> s0
+	s0(("1"))
+	s1(("2"))
+	s2(("typo"))
+	s0 -->|"/foo/"| s1
+	s1 -->|"/bar/"| s2
+`
+	if g.Mermaid() != expected {
+		t.Errorf("Mermaid() =\n%s\nwant\n%s", g.Mermaid(), expected)
+	}
+}
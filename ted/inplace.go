@@ -0,0 +1,104 @@
+package ted
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ahalbert/ted/ted/runner"
+)
+
+// RunInPlace runs the program over the file name and replaces the file with
+// the output. The output is written to a temporary file in the same
+// directory, which is renamed over name only once the run has finished, so
+// name is left untouched if the program fails. If backupSuffix is not empty
+// the original is kept as name+backupSuffix. Symlinks are followed, so the
+// file they point to is edited and the link is kept.
+//
+// A program that runs exit N still replaces the file, and the *runner.ExitError
+// is returned afterwards.
+func (p *Program) RunInPlace(ctx context.Context, name string, backupSuffix string, opts Options) error {
+	path, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return &runner.IOError{Err: err}
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return &runner.IOError{Err: err}
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return &runner.IOError{Name: name, Err: err}
+	}
+	if !info.Mode().IsRegular() {
+		return &runner.IOError{Name: name, Err: errors.New("not a regular file")}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".ted*")
+	if err != nil {
+		return &runner.IOError{Err: err}
+	}
+	//Once the rename has happened there is nothing left to remove.
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	out := bufio.NewWriter(tmp)
+	runErr := p.Run(ctx, in, out, opts)
+	var exitErr *runner.ExitError
+	if runErr != nil && !errors.As(runErr, &exitErr) {
+		return runErr
+	}
+
+	if err := out.Flush(); err != nil {
+		return &runner.IOError{Name: tmp.Name(), Err: err}
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		return &runner.IOError{Name: tmp.Name(), Err: err}
+	}
+	if err := tmp.Close(); err != nil {
+		return &runner.IOError{Name: tmp.Name(), Err: err}
+	}
+	if backupSuffix != "" {
+		if err := backup(path, path+backupSuffix); err != nil {
+			return &runner.IOError{Name: path + backupSuffix, Err: err}
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return &runner.IOError{Err: err}
+	}
+	return runErr
+}
+
+// backup makes dst a copy of src, hard linking it when the filesystem allows.
+func backup(src, dst string) error {
+	if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if os.Link(src, dst) == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copying %s: %w", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,92 @@
+package ted
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ahalbert/ted/ted/runner"
+)
+
+func TestRunInPlace(t *testing.T) {
+	tests := []struct {
+		program string
+		suffix  string
+		content string // expected content of the file afterwards
+		backup  bool
+		failed  bool // expect the run to fail and the file to be left alone
+	}{
+		{`/b/ do s/b/B/`, "", "a\nB\nc\n", false, false},
+		{`/b/ do s/b/B/`, ".bak", "a\nB\nc\n", true, false},
+		{`/b/ println $missing`, ".bak", "a\nb\nc\n", false, true},
+	}
+
+	for i, tt := range tests {
+		dir := t.TempDir()
+		name := filepath.Join(dir, "input.txt")
+		if err := os.WriteFile(name, []byte("a\nb\nc\n"), 0640); err != nil {
+			t.Fatal(err)
+		}
+		prog, err := Compile(tt.program)
+		if err != nil {
+			t.Fatalf("test[%d] - compile error: %v", i, err)
+		}
+
+		err = prog.RunInPlace(context.Background(), name, tt.suffix, Options{})
+		var runtimeErr *runner.RuntimeError
+		if tt.failed != errors.As(err, &runtimeErr) {
+			t.Errorf("test[%d] - expected failure=%t, got err=%v", i, tt.failed, err)
+		}
+		if !tt.failed && err != nil {
+			t.Errorf("test[%d] - unexpected error: %v", i, err)
+		}
+
+		content, _ := os.ReadFile(name)
+		if string(content) != tt.content {
+			t.Errorf("test[%d] - content wrong. expected=%q, got=%q", i, tt.content, string(content))
+		}
+		info, _ := os.Stat(name)
+		if info.Mode().Perm() != 0640 {
+			t.Errorf("test[%d] - mode not kept, got %v", i, info.Mode().Perm())
+		}
+		backup, err := os.ReadFile(name + tt.suffix)
+		if tt.backup && (err != nil || string(backup) != "a\nb\nc\n") {
+			t.Errorf("test[%d] - backup wrong. got=%q, err=%v", i, string(backup), err)
+		}
+		entries, _ := os.ReadDir(dir)
+		expected := 1
+		if tt.backup {
+			expected = 2
+		}
+		if len(entries) != expected {
+			t.Errorf("test[%d] - expected %d files left in the directory, got %d", i, expected, len(entries))
+		}
+	}
+}
+
+func TestRunInPlaceSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.txt")
+	link := filepath.Join(dir, "link.txt")
+	if err := os.WriteFile(target, []byte("b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	prog, err := Compile(`do s/b/B/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.RunInPlace(context.Background(), link, "", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Lstat(link); info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link was replaced by a regular file")
+	}
+	if content, _ := os.ReadFile(target); string(content) != "B\n" {
+		t.Errorf("target content wrong. expected=%q, got=%q", "B\n", string(content))
+	}
+}
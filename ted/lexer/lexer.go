@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"slices"
+	"strings"
 
 	"github.com/ahalbert/ted/ted/token"
 )
@@ -13,6 +14,7 @@ type Lexer struct {
 	ch           byte // current char under examination
 	lineNum      int
 	linePosition int
+	lineStart    int // position of the first char of the current line
 }
 
 func New(input string) *Lexer {
@@ -21,6 +23,19 @@ func New(input string) *Lexer {
 	return l
 }
 
+// Labels returns the names of the states the input declares, including
+// any named after a keyword.
+func (l *Lexer) Labels() map[string]bool {
+	labels := make(map[string]bool)
+	scan := New(l.input)
+	for tok := scan.NextToken(); tok.Type != token.EOF; tok = scan.NextToken() {
+		if tok.Type == token.LABEL {
+			labels[tok.Literal] = true
+		}
+	}
+	return labels
+}
+
 func (l *Lexer) readChar() {
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
@@ -30,6 +45,7 @@ func (l *Lexer) readChar() {
 		if l.ch == '\n' {
 			l.lineNum++
 			l.linePosition = 1
+			l.lineStart = l.readPosition + 1
 		}
 	}
 	l.linePosition++
@@ -49,6 +65,7 @@ func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	line, column, start := l.lineNum, l.position-l.lineStart+1, l.position
 
 	switch l.ch {
 	case '/':
@@ -68,11 +85,12 @@ func (l *Lexer) NextToken() token.Token {
 		l.readChar()
 		tok = l.newToken(token.STRING, l.readUntilChar('`'))
 	case '-':
-		l.readChar()
-		if l.ch == '-' && l.peek(1) == ">" {
+		if strings.HasPrefix(l.input[l.readPosition:], "->") {
+			l.readChar()
 			l.readChar()
 			tok = l.newToken(token.RESET, "-->")
-		} else if l.ch == '>' {
+		} else if strings.HasPrefix(l.input[l.readPosition:], ">") {
+			l.readChar()
 			tok = l.newToken(token.GOTO, "->")
 		} else {
 			tok = l.newToken(token.MINUS, "-")
@@ -92,12 +110,65 @@ func (l *Lexer) NextToken() token.Token {
 		tok = l.newToken(token.LPAREN, "(")
 	case ')':
 		tok = l.newToken(token.RPAREN, ")")
+	case '[':
+		tok = l.newToken(token.LBRACKET, "[")
+	case ']':
+		tok = l.newToken(token.RBRACKET, "]")
 	case ',':
 		tok = l.newToken(token.COMMA, ",")
 	case ':':
 		tok = l.newToken(token.COLON, ":")
 	case ';':
 		tok = l.newToken(token.SEMICOLON, ";")
+	case '!':
+		if l.peek(1) == "=" {
+			l.readChar()
+			tok = l.newToken(token.NOT_EQ, "!=")
+		} else if l.peek(1) == "~" {
+			l.readChar()
+			tok = l.newToken(token.NOT_MATCH, "!~")
+		} else {
+			tok = l.newToken(token.BANG, "!")
+		}
+	case '<':
+		if l.peek(1) == "=" {
+			l.readChar()
+			tok = l.newToken(token.LT_EQ, "<=")
+		} else {
+			tok = l.newToken(token.LT, "<")
+		}
+	case '>':
+		if l.peek(1) == "=" {
+			l.readChar()
+			tok = l.newToken(token.GT_EQ, ">=")
+		} else {
+			tok = l.newToken(token.GT, ">")
+		}
+	case '&':
+		if l.peek(1) == "&" {
+			l.readChar()
+			tok = l.newToken(token.AND, "&&")
+		} else {
+			tok = l.newToken(token.ILLEGAL, "&")
+		}
+	case '|':
+		if l.peek(1) == "|" {
+			l.readChar()
+			tok = l.newToken(token.OR, "||")
+		} else {
+			tok = l.newToken(token.ILLEGAL, "|")
+		}
+	case '.':
+		if l.peek(1) == "." {
+			l.readChar()
+			tok = l.newToken(token.CONCAT, "..")
+		} else {
+			tok = l.newToken(token.ILLEGAL, ".")
+		}
+	case '~':
+		tok = l.newToken(token.MATCH, "~")
+	case '%':
+		tok = l.newToken(token.PERCENT, "%")
 	case '+':
 		tok = l.newToken(token.PLUS, "+")
 	case '*':
@@ -117,9 +188,12 @@ func (l *Lexer) NextToken() token.Token {
 			case token.DOUNTIL:
 				tok.Literal = l.readDo()
 				l.readChar()
-			case token.IDENT:
+			default:
+				//Any word but do and dountil can name a state, even a keyword.
 				tok = l.handleIdentfierSpecialCases(tok)
 			}
+			tok.LineNum, tok.Position = line, column
+			tok.EndLineNum, tok.EndPosition = l.end(line, column, start)
 			return tok
 		} else {
 			tok = l.newToken(token.ILLEGAL, string(l.ch))
@@ -128,9 +202,21 @@ func (l *Lexer) NextToken() token.Token {
 
 	l.readChar()
 
+	tok.LineNum, tok.Position = line, column
+	tok.EndLineNum, tok.EndPosition = l.end(line, column, start)
 	return tok
 }
 
+// end returns the line and column just past a token that began at start, at
+// line and column, and runs up to the current character.
+func (l *Lexer) end(line int, column int, start int) (int, int) {
+	src := l.input[min(start, len(l.input)):min(l.position, len(l.input))]
+	if i := strings.LastIndexByte(src, '\n'); i >= 0 {
+		return line + strings.Count(src, "\n"), len(src) - i
+	}
+	return line, column + len(src)
+}
+
 func (l *Lexer) handleIdentfierSpecialCases(t token.Token) token.Token {
 	if l.ch == ':' {
 		l.readChar()
@@ -161,6 +247,19 @@ func (l *Lexer) readIdentifier() string {
 	for isLetter(l.ch) && l.ch != 0 {
 		l.readChar()
 	}
+	//$.name refers to a column of the record, so it may contain dots.
+	if l.input[position:l.position] == "$" && l.ch == '.' {
+		for isLetter(l.ch) || l.ch == '.' {
+			l.readChar()
+		}
+	}
+	//A number may have a fractional part, as in 12.5.
+	if isNumber(l.input[position:l.position]) && l.ch == '.' && l.readPosition < len(l.input) && isDigit(l.input[l.readPosition]) {
+		l.readChar()
+		for isDigit(l.ch) {
+			l.readChar()
+		}
+	}
 	return l.input[position:l.position]
 }
 
@@ -181,6 +280,19 @@ func (l *Lexer) readUntilChar(chars ...byte) string {
 	return l.input[position:l.position]
 }
 
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+func isNumber(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return s != ""
+}
+
 func isLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' || ch == '_' || ch == '$' || ch == '@'
 }
@@ -115,6 +115,9 @@ func TestReadIdentifier(t *testing.T) {
 		{"059mixed_Case$", "059mixed_Case$"},
 		{"", ""},
 		{"!notIdentifier", ""},
+		{"$.name", "$.name"},
+		{"$.trace.id rest", "$.trace.id"},
+		{"a.b", "a"},
 	}
 
 	for i, tt := range tests {
@@ -205,12 +208,12 @@ func TestNextToken(t *testing.T) {
 			},
 		},
 		{
-			input: `!`, // illegal char test
+			input: `?`, // illegal char test
 			expectedTokens: []struct {
 				expectedType    token.TokenType
 				expectedLiteral string
 			}{
-				{token.ILLEGAL, "!"},
+				{token.ILLEGAL, "?"},
 				{token.EOF, ""},
 			},
 		},
@@ -321,6 +324,98 @@ func TestNextToken(t *testing.T) {
 				{token.EOF, ""},
 			},
 		},
+		{
+			input: `if a <= 1 && b >= 2 || !c != d .. e % 3 < 4 > 5 ~ /x y/ !~ "z"`,
+			expectedTokens: []struct {
+				expectedType    token.TokenType
+				expectedLiteral string
+			}{
+				{token.IF, "if"},
+				{token.IDENT, "a"},
+				{token.LT_EQ, "<="},
+				{token.IDENT, "1"},
+				{token.AND, "&&"},
+				{token.IDENT, "b"},
+				{token.GT_EQ, ">="},
+				{token.IDENT, "2"},
+				{token.OR, "||"},
+				{token.BANG, "!"},
+				{token.IDENT, "c"},
+				{token.NOT_EQ, "!="},
+				{token.IDENT, "d"},
+				{token.CONCAT, ".."},
+				{token.IDENT, "e"},
+				{token.PERCENT, "%"},
+				{token.IDENT, "3"},
+				{token.LT, "<"},
+				{token.IDENT, "4"},
+				{token.GT, ">"},
+				{token.IDENT, "5"},
+				{token.MATCH, "~"},
+				{token.REGEX, "x y"},
+				{token.NOT_MATCH, "!~"},
+				{token.STRING, "z"},
+				{token.EOF, ""},
+			},
+		},
+		{
+			input: `let seen[$1] = [1, 2] if $1 in seen for k in seen delete seen[k]`,
+			expectedTokens: []struct {
+				expectedType    token.TokenType
+				expectedLiteral string
+			}{
+				{token.LET, "let"},
+				{token.IDENT, "seen"},
+				{token.LBRACKET, "["},
+				{token.IDENT, "$1"},
+				{token.RBRACKET, "]"},
+				{token.ASSIGN, "="},
+				{token.LBRACKET, "["},
+				{token.IDENT, "1"},
+				{token.COMMA, ","},
+				{token.IDENT, "2"},
+				{token.RBRACKET, "]"},
+				{token.IF, "if"},
+				{token.IDENT, "$1"},
+				{token.IN, "in"},
+				{token.IDENT, "seen"},
+				{token.FOR, "for"},
+				{token.IDENT, "k"},
+				{token.IN, "in"},
+				{token.IDENT, "seen"},
+				{token.DELETE, "delete"},
+				{token.IDENT, "seen"},
+				{token.LBRACKET, "["},
+				{token.IDENT, "k"},
+				{token.RBRACKET, "]"},
+				{token.EOF, ""},
+			},
+		},
+		{
+			input: `let x = -12.5 * $F2 -1 .. 2..3 -> a -->`,
+			expectedTokens: []struct {
+				expectedType    token.TokenType
+				expectedLiteral string
+			}{
+				{token.LET, "let"},
+				{token.IDENT, "x"},
+				{token.ASSIGN, "="},
+				{token.MINUS, "-"},
+				{token.IDENT, "12.5"},
+				{token.ASTERISK, "*"},
+				{token.IDENT, "$F2"},
+				{token.MINUS, "-"},
+				{token.IDENT, "1"},
+				{token.CONCAT, ".."},
+				{token.IDENT, "2"},
+				{token.CONCAT, ".."},
+				{token.IDENT, "3"},
+				{token.GOTO, "->"},
+				{token.IDENT, "a"},
+				{token.RESET, "-->"},
+				{token.EOF, ""},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -339,3 +434,40 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "ab: /x/ -> cd\n  let y = 1\n\tprintln \"s\" \"t\nu\""
+	tests := []struct {
+		expectedLiteral  string
+		expectedLine     int
+		expectedPosition int
+		expectedEnd      [2]int // line and column just past the token
+	}{
+		{"ab", 1, 1, [2]int{1, 4}},
+		{"x", 1, 5, [2]int{1, 8}},
+		{"->", 1, 9, [2]int{1, 11}},
+		{"cd", 1, 12, [2]int{1, 14}},
+		{"let", 2, 3, [2]int{2, 6}},
+		{"y", 2, 7, [2]int{2, 8}},
+		{"=", 2, 9, [2]int{2, 10}},
+		{"1", 2, 11, [2]int{2, 12}},
+		{"println", 3, 2, [2]int{3, 9}},
+		{"s", 3, 10, [2]int{3, 13}},
+		{"t\nu", 3, 14, [2]int{4, 3}},
+		{"", 4, 3, [2]int{4, 3}},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.LineNum != tt.expectedLine || tok.Position != tt.expectedPosition {
+			t.Errorf("tests[%d] - %q at %d:%d, want %d:%d", i, tok.Literal, tok.LineNum, tok.Position, tt.expectedLine, tt.expectedPosition)
+		}
+		if tok.EndLineNum != tt.expectedEnd[0] || tok.EndPosition != tt.expectedEnd[1] {
+			t.Errorf("tests[%d] - %q ends at %d:%d, want %d:%d", i, tok.Literal, tok.EndLineNum, tok.EndPosition, tt.expectedEnd[0], tt.expectedEnd[1])
+		}
+	}
+}
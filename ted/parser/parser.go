@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/ahalbert/ted/ted/ast"
 	"github.com/ahalbert/ted/ted/lexer"
@@ -12,24 +13,38 @@ import (
 const (
 	_ int = iota
 	LOWEST
-	EQUALS      // ==
-	LESSGREATER // > or <
+	OR          // ||
+	AND         // &&
+	EQUALS      // == or ~
+	LESSGREATER // > or < or in
+	CONCAT      // ..
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
 	CALL        // myFunction(X)
+	INDEX       // list[X]
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
+	token.OR:        OR,
+	token.AND:       AND,
+	token.EQ:        EQUALS,
+	token.NOT_EQ:    EQUALS,
+	token.MATCH:     EQUALS,
+	token.NOT_MATCH: EQUALS,
+	token.LT:        LESSGREATER,
+	token.GT:        LESSGREATER,
+	token.LT_EQ:     LESSGREATER,
+	token.GT_EQ:     LESSGREATER,
+	token.IN:        LESSGREATER,
+	token.CONCAT:    CONCAT,
+	token.PLUS:      SUM,
+	token.MINUS:     SUM,
+	token.SLASH:     PRODUCT,
+	token.ASTERISK:  PRODUCT,
+	token.PERCENT:   PRODUCT,
+	token.LPAREN:    CALL,
+	token.LBRACKET:  INDEX,
 }
 
 type (
@@ -41,12 +56,17 @@ type Parser struct {
 	l      *lexer.Lexer
 	errors []string
 
+	prevToken      token.Token // the last token read, which ends the node just parsed
 	curToken       token.Token
 	peekToken      token.Token
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 
 	AnonymousStates int
+
+	// labels are the states the program declares, so that -> can go to a
+	// state named after a keyword.
+	labels map[string]bool
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -54,6 +74,7 @@ func New(l *lexer.Lexer) *Parser {
 		l:               l,
 		errors:          []string{},
 		AnonymousStates: 1,
+		labels:          l.Labels(),
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
@@ -65,6 +86,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.LBRACKET, p.parseListLiteral)
 
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
@@ -74,8 +96,18 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.IN, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.CONCAT, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.MATCH, p.parseMatchExpression)
+	p.registerInfix(token.NOT_MATCH, p.parseMatchExpression)
 
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
+	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 	// Read two tokens, so curToken and peekToken are both set
 	p.nextToken()
 	p.nextToken()
@@ -92,6 +124,7 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 }
 
 func (p *Parser) nextToken() {
+	p.prevToken = p.curToken
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
 }
@@ -128,6 +161,20 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	}
 }
 
+// extentSetter is a node that can record the span it was parsed from.
+type extentSetter interface {
+	SetExtent(first token.Token, last token.Token)
+}
+
+// setExtent records that node was parsed from first to the last token read.
+// It is skipped if there were errors since errs, as a failed parse can leave
+// node nil, and the tree is thrown away anyway.
+func (p *Parser) setExtent(node ast.Node, first token.Token, errs int) {
+	if n, ok := node.(extentSetter); ok && len(p.errors) == errs {
+		n.SetExtent(first, p.prevToken)
+	}
+}
+
 func (p *Parser) addError(msg string) {
 	m := fmt.Sprintf("parser error at line %d col %d: ", p.curToken.LineNum, p.curToken.Position) + msg
 	p.errors = append(p.errors, m)
@@ -140,6 +187,18 @@ func (p *Parser) peekError(t token.TokenType) {
 	p.addError(msg)
 }
 
+// unexpectedTokenError reports that the current token isn't the expected
+// one, naming it as a reserved word if it is one, and skips it.
+func (p *Parser) unexpectedTokenError(expected string) {
+	if token.LookupIdent(p.curToken.Literal) != token.IDENT {
+		p.addError(fmt.Sprintf("expected %s, got reserved word %s", expected, p.curToken.Literal))
+	} else if string(p.curToken.Type) == p.curToken.Literal {
+		p.addError(fmt.Sprintf("expected %s, got %s", expected, p.curToken.Literal))
+	} else {
+		p.addError(fmt.Sprintf("expected %s, got %s %s", expected, p.curToken.Type, p.curToken.Literal))
+	}
+}
+
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
 	p.addError(msg)
@@ -151,15 +210,19 @@ func (p *Parser) ParseFSA() (ast.FSA, []string) {
 	p.errors = []string{}
 
 	for !p.curTokenIs(token.EOF) {
+		first, errs := p.curToken, len(p.errors)
 		stmt := p.parseStatement()
+		p.setExtent(stmt, first, errs)
 		program.Statements = append(program.Statements, stmt)
 		switch stmt.(type) {
 		case *ast.StateStatement:
 			statename := stmt.(*ast.StateStatement).StateName
 			for p.curTokenIs(token.COMMA) {
-				stmt := &ast.StateStatement{StateName: statename}
 				p.nextToken()
+				first, errs := p.curToken, len(p.errors)
+				stmt := &ast.StateStatement{Token: p.curToken, StateName: statename}
 				stmt.Action = p.parseAction()
+				p.setExtent(stmt, first, errs)
 				program.Statements = append(program.Statements, stmt)
 			}
 		}
@@ -169,7 +232,7 @@ func (p *Parser) ParseFSA() (ast.FSA, []string) {
 }
 
 func (p *Parser) parseStatement() ast.Statement {
-	statement := &ast.StateStatement{}
+	statement := &ast.StateStatement{Token: p.curToken}
 	if p.curTokenIs(token.LABEL) {
 		statement.StateName = p.curToken.Literal
 		p.nextToken()
@@ -191,6 +254,7 @@ func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 		p.addError("expected function identifier")
 		return nil
 	}
+	function.Token = p.curToken
 	function.Name = p.curToken.Literal
 	p.nextToken()
 	function.Function = p.parseFunctionLiteral()
@@ -199,6 +263,7 @@ func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 
 func (p *Parser) parseAction() ast.Action {
 	var action ast.Action
+	first, errs := p.curToken, len(p.errors)
 	switch p.curToken.Type {
 	case token.LBRACE:
 		action = p.parseActionBlock()
@@ -230,8 +295,36 @@ func (p *Parser) parseAction() ast.Action {
 		action = p.parseMoveHeadAction()
 	case token.FASTFWD:
 		action = p.parseMoveHeadAction()
+	case token.PAUSE:
+		action = p.parseMoveHeadAction()
+	case token.PLAY:
+		action = p.parseMoveHeadAction()
+	case token.SEEK:
+		action = p.parseMoveHeadAction()
+	case token.GOTOMARK:
+		action = p.parseMoveHeadAction()
+	case token.MARK:
+		action = p.parseMarkAction()
+	case token.WRITE:
+		action = p.parseEditTapeAction()
+	case token.INSERT:
+		action = p.parseEditTapeAction()
+	case token.DELETE:
+		action = p.parseDeleteAction()
 	case token.IF:
 		action = p.parseIfAction()
+	case token.FOR:
+		action = p.parseForAction()
+	case token.WHILE:
+		action = p.parseWhileAction()
+	case token.BREAK:
+		action = p.parseLoopControlAction()
+	case token.CONTINUE:
+		action = p.parseLoopControlAction()
+	case token.RETURN:
+		action = p.parseReturnAction()
+	case token.EXIT:
+		action = p.parseExitAction()
 	case token.ILLEGAL:
 		p.addError(fmt.Sprintf("expected action, got illegal token %s", p.curToken.Literal))
 		return nil
@@ -240,13 +333,18 @@ func (p *Parser) parseAction() ast.Action {
 		// p.addError(fmt.Sprintf("expected action, got %s %s", p.curToken.Type, p.curToken.Literal))
 		// return nil
 	}
+	p.setExtent(action, first, errs)
 	return action
 }
 
 func (p *Parser) parseActionBlock() *ast.ActionBlock {
-	action := &ast.ActionBlock{}
+	action := &ast.ActionBlock{Token: p.curToken}
 	p.nextToken()
 	for !p.curTokenIs(token.RBRACE) {
+		if p.curTokenIs(token.EOF) {
+			p.addError("expected } to close the block opened at line " + strconv.Itoa(action.Token.LineNum))
+			return action
+		}
 		action.Actions = append(action.Actions, p.parseAction())
 	}
 	p.nextToken()
@@ -254,16 +352,17 @@ func (p *Parser) parseActionBlock() *ast.ActionBlock {
 }
 
 func (p *Parser) parseRegexAction() *ast.RegexAction {
-	action := &ast.RegexAction{Rule: p.curToken.Literal}
+	action := &ast.RegexAction{Token: p.curToken, Rule: p.curToken.Literal}
 	p.nextToken()
 	action.Action = p.parseAction()
 	return action
 }
 
 func (p *Parser) parseGotoAction() *ast.GotoAction {
-	action := &ast.GotoAction{}
-	if p.peekTokenIs(token.IDENT) {
+	action := &ast.GotoAction{Token: p.curToken}
+	if p.peekTokenIs(token.IDENT) || p.labels[p.peekToken.Literal] && token.LookupIdent(p.peekToken.Literal) != token.IDENT {
 		p.nextToken()
+		action.Token = p.curToken
 		action.Target = p.curToken.Literal
 	}
 
@@ -272,45 +371,45 @@ func (p *Parser) parseGotoAction() *ast.GotoAction {
 }
 
 func (p *Parser) parseResetAction() *ast.ResetAction {
-	action := &ast.ResetAction{}
+	action := &ast.ResetAction{Token: p.curToken}
 
 	p.nextToken()
 	return action
 }
 
 func (p *Parser) parseDoAction() *ast.DoSedAction {
-	action := &ast.DoSedAction{Command: p.curToken.Literal}
+	action := &ast.DoSedAction{Token: p.curToken, Command: p.curToken.Literal}
 	action.Variable = p.helpCheckForOptionalVarArg()
 	return action
 }
 
 func (p *Parser) parseDoUntilAction() *ast.DoUntilSedAction {
-	action := &ast.DoUntilSedAction{Command: p.curToken.Literal}
+	action := &ast.DoUntilSedAction{Token: p.curToken, Command: p.curToken.Literal}
 	action.Variable = p.helpCheckForOptionalVarArg()
 	action.Action = p.parseAction()
 	return action
 }
 
 func (p *Parser) parsePrintAction() *ast.PrintAction {
-	action := &ast.PrintAction{}
+	action := &ast.PrintAction{Token: p.curToken}
 	action.Expression = p.helpCheckForOptionalExpr()
 	return action
 }
 
 func (p *Parser) parsePrintLnAction() *ast.PrintLnAction {
-	action := &ast.PrintLnAction{}
+	action := &ast.PrintLnAction{Token: p.curToken}
 	action.Expression = p.helpCheckForOptionalExpr()
 	return action
 }
 
 func (p *Parser) parseClearAction() *ast.ClearAction {
-	action := &ast.ClearAction{}
+	action := &ast.ClearAction{Token: p.curToken}
 	action.Variable = p.helpCheckForOptionalVarArg()
 	return action
 }
 
 func (p *Parser) parseStartStopCaptureAction() *ast.StartStopCaptureAction {
-	action := &ast.StartStopCaptureAction{Command: p.curToken.Literal}
+	action := &ast.StartStopCaptureAction{Token: p.curToken, Command: p.curToken.Literal}
 	p.nextToken()
 	if p.curTokenIs(token.CAPTURE) {
 		action.Variable = p.helpCheckForOptionalVarArg()
@@ -333,33 +432,43 @@ func (p *Parser) helpCheckForOptionalVarArg() string {
 }
 
 func (p *Parser) helpCheckForOptionalExpr() ast.Expression {
+	tok := p.curToken
 	p.nextToken()
 	expr := p.parseExpression(LOWEST)
 	if expr != nil {
 		return expr
 	} else {
-		return &ast.Identifier{Value: "$_"}
+		return &ast.Identifier{Token: tok, Value: "$_"}
 	}
 }
 
 func (p *Parser) parseCaptureAction() *ast.CaptureAction {
-	action := &ast.CaptureAction{}
+	action := &ast.CaptureAction{Token: p.curToken}
 	action.Variable = p.helpCheckForOptionalVarArg()
 	return action
 }
 
 func (p *Parser) parseAssignAction() *ast.AssignAction {
-	action := &ast.AssignAction{}
+	action := &ast.AssignAction{Token: p.curToken}
 	p.nextToken()
 	if p.curTokenIs(token.IDENT) {
 		//TODO: Check is valid variable
 		action.Target = p.curToken.Literal
+		p.nextToken()
+	} else if token.LookupIdent(p.curToken.Literal) != token.IDENT {
+		// Parse the rest, so that only the reserved word is reported.
+		action.Target = p.curToken.Literal
+		p.unexpectedTokenError("variable")
 	} else {
 		p.addError(fmt.Sprintf("expected variable, got %s %s", p.curToken.Type, p.curToken.Literal))
 		return nil
 	}
 
-	p.nextToken()
+	if p.curTokenIs(token.LBRACKET) {
+		if action.Index = p.parseIndex(); action.Index == nil {
+			return nil
+		}
+	}
 	if !p.curTokenIs(token.ASSIGN) {
 		p.addError(fmt.Sprintf("expected =, got %s %s", p.curToken.Type, p.curToken.Literal))
 		return nil
@@ -372,7 +481,7 @@ func (p *Parser) parseAssignAction() *ast.AssignAction {
 }
 
 func (p *Parser) parseIfAction() *ast.IfAction {
-	action := &ast.IfAction{}
+	action := &ast.IfAction{Token: p.curToken}
 	p.nextToken()
 	action.Condition = p.parseExpression(LOWEST)
 
@@ -385,35 +494,147 @@ func (p *Parser) parseIfAction() *ast.IfAction {
 	return action
 }
 
+func (p *Parser) parseReturnAction() *ast.ReturnAction {
+	action := &ast.ReturnAction{Token: p.curToken}
+	p.nextToken()
+	action.Expression = p.parseExpression(LOWEST)
+	return action
+}
+
+func (p *Parser) parseExitAction() *ast.ExitAction {
+	action := &ast.ExitAction{Token: p.curToken}
+	p.nextToken()
+	action.Expression = p.parseExpression(LOWEST)
+	return action
+}
+
 func (p *Parser) parseMoveHeadAction() *ast.MoveHeadAction {
 	t := p.curToken.Type
-	action := &ast.MoveHeadAction{Command: p.curToken.Literal}
+	action := &ast.MoveHeadAction{Token: p.curToken, Command: p.curToken.Literal}
 	p.nextToken()
-	if t == token.REWIND || t == token.FASTFWD {
+	if t == token.REWIND && p.curTokenIs(token.IDENT) && p.curToken.Literal == "to" && p.peekTokenIs(token.IDENT) {
+		p.nextToken()
+		action.Mark = p.curToken.Literal
+		p.nextToken()
+	} else if t == token.GOTOMARK {
+		if !p.curTokenIs(token.MARK) || !p.peekTokenIs(token.IDENT) {
+			p.addError(fmt.Sprintf("goto expected mark NAME, got %s %s", p.curToken.Type, p.curToken.Literal))
+			return nil
+		}
+		p.nextToken()
+		action.Mark = p.curToken.Literal
+		p.nextToken()
+	} else if t == token.REWIND || t == token.FASTFWD {
 		if p.curTokenIs(token.REGEX) {
 			action.Regex = p.curToken.Literal
 			p.nextToken()
-		} else {
-			p.addError(fmt.Sprintf("%s expected regex, got %s %s", action.Command, p.curToken.Type, p.curToken.Literal))
+		} else if action.Count = p.parseExpression(LOWEST); action.Count == nil {
+			p.addError(fmt.Sprintf("%s expected regex or count, got %s %s", action.Command, p.curToken.Type, p.curToken.Literal))
+			return nil
+		}
+	} else if t == token.SEEK {
+		if p.curTokenIs(token.IDENT) && p.curToken.Literal == "end" {
+			action.ToEnd = true
+			p.nextToken()
+		} else if action.Count = p.parseExpression(LOWEST); action.Count == nil {
+			p.addError(fmt.Sprintf("seek expected record number or end, got %s %s", p.curToken.Type, p.curToken.Literal))
+			return nil
+		}
+	}
+	return action
+}
+
+func (p *Parser) parseMarkAction() *ast.MarkAction {
+	action := &ast.MarkAction{Token: p.curToken}
+	p.nextToken()
+	if !p.curTokenIs(token.IDENT) {
+		p.addError(fmt.Sprintf("mark expected name, got %s %s", p.curToken.Type, p.curToken.Literal))
+		return nil
+	}
+	action.Name = p.curToken.Literal
+	p.nextToken()
+	return action
+}
+
+func (p *Parser) parseEditTapeAction() *ast.EditTapeAction {
+	action := &ast.EditTapeAction{Token: p.curToken, Command: p.curToken.Literal}
+	switch p.curToken.Type {
+	case token.WRITE:
+		action.Expression = p.helpCheckForOptionalExpr()
+	case token.INSERT:
+		p.nextToken()
+		if !p.curTokenIs(token.IDENT) || (p.curToken.Literal != "before" && p.curToken.Literal != "after") {
+			p.addError(fmt.Sprintf("insert expected before or after, got %s %s", p.curToken.Type, p.curToken.Literal))
+			return nil
+		}
+		action.After = p.curToken.Literal == "after"
+		p.nextToken()
+		if action.Expression = p.parseExpression(LOWEST); action.Expression == nil {
+			p.addError(fmt.Sprintf("insert expected a record, got %s %s", p.curToken.Type, p.curToken.Literal))
 			return nil
 		}
 	}
 	return action
 }
 
+// parseDeleteAction parses delete NAME[index], or delete on its own, which
+// deletes the record under the head.
+func (p *Parser) parseDeleteAction() ast.Action {
+	tok := p.curToken
+	p.nextToken()
+	if p.curTokenIs(token.IDENT) && !p.peekTokenIs(token.LBRACKET) && !p.peekTokenIs(token.LPAREN) {
+		p.addError(fmt.Sprintf("delete %s needs an index, use clear %s to empty a list or map", p.curToken.Literal, p.curToken.Literal))
+		return nil
+	}
+	if !p.curTokenIs(token.IDENT) || !p.peekTokenIs(token.LBRACKET) {
+		return &ast.EditTapeAction{Token: tok, Command: tok.Literal}
+	}
+	action := &ast.DeleteElementAction{Token: tok, Variable: p.curToken.Literal}
+	p.nextToken()
+	if action.Index = p.parseIndex(); action.Index == nil {
+		return nil
+	}
+	return action
+}
+
+func (p *Parser) parseForAction() *ast.ForAction {
+	action := &ast.ForAction{Token: p.curToken}
+	p.nextToken()
+	if !p.curTokenIs(token.IDENT) {
+		p.addError(fmt.Sprintf("for expected variable, got %s %s", p.curToken.Type, p.curToken.Literal))
+		return nil
+	}
+	action.Variable = p.curToken.Literal
+	p.nextToken()
+	if !p.curTokenIs(token.IN) {
+		p.addError(fmt.Sprintf("for expected in, got %s %s", p.curToken.Type, p.curToken.Literal))
+		return nil
+	}
+	p.nextToken()
+	if action.Collection = p.parseExpression(LOWEST); action.Collection == nil {
+		p.addError(fmt.Sprintf("for expected a list or map, got %s %s", p.curToken.Type, p.curToken.Literal))
+		return nil
+	}
+	action.Action = p.parseAction()
+	return action
+}
+
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		//p.noPrefixParseFnError(p.curToken.Type)
 		return nil
 	}
+	first, errs := p.curToken, len(p.errors)
 	leftExp := prefix()
+	p.setExtent(leftExp, first, errs)
 	for precedence < p.curPrecedence() {
 		infix := p.infixParseFns[p.curToken.Type]
 		if infix == nil {
 			return leftExp
 		}
 		leftExp = infix(leftExp)
+		p.setExtent(leftExp, first, errs)
 	}
 
 	return leftExp
@@ -423,25 +644,30 @@ func (p *Parser) parseIdentifierExpr() ast.Expression {
 	defer p.nextToken()
 	val, err := strconv.Atoi(p.curToken.Literal)
 	if err == nil {
-		return &ast.IntegerLiteral{Value: val}
+		return &ast.IntegerLiteral{Token: p.curToken, Value: val}
+	}
+	if strings.Contains(p.curToken.Literal, ".") {
+		if val, err := strconv.ParseFloat(p.curToken.Literal, 64); err == nil {
+			return &ast.FloatLiteral{Token: p.curToken, Value: val}
+		}
 	}
 	if p.curToken.Literal == "false" {
-		return &ast.Boolean{Value: false}
+		return &ast.Boolean{Token: p.curToken, Value: false}
 	}
 	if p.curToken.Literal == "true" {
-		return &ast.Boolean{Value: true}
+		return &ast.Boolean{Token: p.curToken, Value: true}
 	}
-	return &ast.Identifier{Value: p.curToken.Literal}
+	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 
 func (p *Parser) parseStringLiteralExpr() ast.Expression {
-	lit := &ast.StringLiteral{Value: p.curToken.Literal}
+	lit := &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 	p.nextToken()
 	return lit
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
-	expression := &ast.PrefixExpression{Operator: p.curToken.Literal}
+	expression := &ast.PrefixExpression{Token: p.curToken, Operator: p.curToken.Literal}
 	p.nextToken()
 	expression.Right = p.parseExpression(PREFIX)
 	return expression
@@ -458,8 +684,105 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 	return exp
 }
 
+// parseWhileAction parses while Expr Action.
+func (p *Parser) parseWhileAction() *ast.WhileAction {
+	action := &ast.WhileAction{Token: p.curToken}
+	p.nextToken()
+	if action.Condition = p.parseExpression(LOWEST); action.Condition == nil {
+		p.addError(fmt.Sprintf("while expected a condition, got %s %s", p.curToken.Type, p.curToken.Literal))
+		return nil
+	}
+	action.Action = p.parseAction()
+	return action
+}
+
+func (p *Parser) parseLoopControlAction() *ast.LoopControlAction {
+	action := &ast.LoopControlAction{Token: p.curToken, Command: p.curToken.Literal}
+	p.nextToken()
+	return action
+}
+
+// parseListLiteral parses a list, [a, b], or a map, [k: v, ...] or [:] if
+// it is empty.
+func (p *Parser) parseListLiteral() ast.Expression {
+	tok := p.curToken
+	p.nextToken()
+	if p.curTokenIs(token.COLON) && p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		p.nextToken()
+		return &ast.MapLiteral{Token: tok}
+	}
+
+	var keys, values []ast.Expression
+	isMap := false
+	for !p.curTokenIs(token.RBRACKET) {
+		if len(keys) > 0 {
+			if !p.curTokenIs(token.COMMA) {
+				p.addError(fmt.Sprintf("expected , or ], got %s %s", p.curToken.Type, p.curToken.Literal))
+				return nil
+			}
+			p.nextToken()
+		}
+		var key ast.Expression
+		hasValue := false
+		if p.curTokenIs(token.LABEL) {
+			//k: lexes as a label, since there is no space before the colon.
+			key, hasValue = p.parseIdentifierExpr(), true
+		} else if key = p.parseExpression(LOWEST); key == nil {
+			p.addError(fmt.Sprintf("expected list element, got %s %s", p.curToken.Type, p.curToken.Literal))
+			return nil
+		} else if p.curTokenIs(token.COLON) {
+			p.nextToken()
+			hasValue = true
+		}
+		if len(keys) > 0 && hasValue != isMap {
+			p.addError("a list can't have both elements and key: value pairs")
+			return nil
+		}
+		isMap = hasValue
+		keys = append(keys, key)
+		if hasValue {
+			value := p.parseExpression(LOWEST)
+			if value == nil {
+				p.addError(fmt.Sprintf("expected value for key %s, got %s %s", key.String(), p.curToken.Type, p.curToken.Literal))
+				return nil
+			}
+			values = append(values, value)
+		}
+	}
+	p.nextToken()
+
+	if isMap {
+		return &ast.MapLiteral{Token: tok, Keys: keys, Values: values}
+	}
+	if keys == nil {
+		keys = []ast.Expression{}
+	}
+	return &ast.ListLiteral{Token: tok, Elements: keys}
+}
+
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	if exp.Index = p.parseIndex(); exp.Index == nil {
+		return nil
+	}
+	return exp
+}
+
+// parseIndex parses the [index] the current token opens.
+func (p *Parser) parseIndex() ast.Expression {
+	p.nextToken()
+	index := p.parseExpression(LOWEST)
+	if index == nil || !p.curTokenIs(token.RBRACKET) {
+		p.addError(fmt.Sprintf("expected index and ], got %s %s", p.curToken.Type, p.curToken.Literal))
+		return nil
+	}
+	p.nextToken()
+	return index
+}
+
 func (p *Parser) parseFunctionLiteral() ast.Expression {
-	lit := &ast.FunctionLiteral{}
+	lit := &ast.FunctionLiteral{Token: p.curToken}
 
 	if !p.curTokenIs(token.LPAREN) {
 		p.addError(fmt.Sprintf("expected (, got %s %s", p.curToken.Type, p.curToken.Literal))
@@ -487,13 +810,13 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 
 	p.nextToken()
 
-	ident := &ast.Identifier{Value: p.curToken.Literal}
+	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	identifiers = append(identifiers, ident)
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		ident := &ast.Identifier{Value: p.curToken.Literal}
+		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		identifiers = append(identifiers, ident)
 	}
 
@@ -506,6 +829,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	expression := &ast.InfixExpression{
+		Token:    p.curToken,
 		Operator: p.curToken.Literal,
 		Left:     left,
 	}
@@ -517,8 +841,37 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// parseMatchExpression parses x ~ /regex/, or x ~ Expr where Expr is a
+// string holding a regex.
+func (p *Parser) parseMatchExpression(left ast.Expression) ast.Expression {
+	expression := &ast.InfixExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Left:     left,
+	}
+	precedence := p.curPrecedence()
+	p.nextToken()
+	if expression.Right = p.parseRegexOrExpression(precedence); expression.Right == nil {
+		p.addError(fmt.Sprintf("%s expected a regex, got %s %s", expression.Operator, p.curToken.Type, p.curToken.Literal))
+		return nil
+	}
+	return expression
+}
+
+// parseRegexOrExpression parses an expression, or a /regex/ where one may
+// be given instead: after ~ and !~, and as a function argument. Anywhere
+// else a /regex/ starts a new action.
+func (p *Parser) parseRegexOrExpression(precedence int) ast.Expression {
+	if p.curTokenIs(token.REGEX) {
+		lit := &ast.RegexLiteral{Token: p.curToken, Value: p.curToken.Literal}
+		p.nextToken()
+		return lit
+	}
+	return p.parseExpression(precedence)
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
-	exp := &ast.CallExpression{Function: function}
+	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseCallArguments()
 	return exp
 }
@@ -533,23 +886,27 @@ func (p *Parser) parseCallArguments() []ast.Expression {
 	}
 
 	p.nextToken()
-	args = append(args, p.parseExpression(LOWEST))
+	args = append(args, p.parseRegexOrExpression(LOWEST))
 
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
+	for p.curTokenIs(token.COMMA) {
 		p.nextToken()
-		args = append(args, p.parseExpression(LOWEST))
+		args = append(args, p.parseRegexOrExpression(LOWEST))
 	}
 
-	if !p.expectPeek(token.RPAREN) {
+	if !p.curTokenIs(token.RPAREN) {
+		p.addError(fmt.Sprintf("expected ), got %s %s", p.curToken.Type, p.curToken.Literal))
 		return nil
 	}
+	p.nextToken()
 
 	return args
 }
 
 func (p *Parser) parseExpressionAction() *ast.ExpressionAction {
 	action := &ast.ExpressionAction{}
-	action.Expression = p.parseExpression(LOWEST)
+	if action.Expression = p.parseExpression(LOWEST); action.Expression == nil {
+		p.unexpectedTokenError("action")
+		return nil
+	}
 	return action
 }
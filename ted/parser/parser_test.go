@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ahalbert/ted/ted/ast"
+	"github.com/ahalbert/ted/ted/lexer"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		program string
+		err     string
+	}{
+		{`in`, "expected action, got reserved word in"},
+		{`in.txt`, "expected action, got reserved word in"},
+		{`]`, "expected action, got ]"},
+		{`main: { println "a" ] }`, "expected action, got ]"},
+		{`<`, "expected action, got <"},
+		{`>`, "expected action, got >"},
+		{`<= 1`, "expected action, got <="},
+		{`>= 1`, "expected action, got >="},
+		{`~ /a/`, "expected action, got ~"},
+		{`!~ /a/`, "expected action, got !~"},
+		{`% 2`, "expected action, got %"},
+		{`&& true`, "expected action, got &&"},
+		{`|| true`, "expected action, got ||"},
+		{`.. "a"`, "expected action, got .."},
+		{`/x/ delete seen`, "delete seen needs an index, use clear seen"},
+		{`let exit = 1`, "expected variable, got reserved word exit"},
+		{`let seek = 1`, "expected variable, got reserved word seek"},
+		{`let mark = 1`, "expected variable, got reserved word mark"},
+		{`let goto = 1`, "expected variable, got reserved word goto"},
+		{`let write = 1`, "expected variable, got reserved word write"},
+		{`let insert = 1`, "expected variable, got reserved word insert"},
+		{`let delete = 1`, "expected variable, got reserved word delete"},
+		{`let for = 1`, "expected variable, got reserved word for"},
+		{`let while = 1`, "expected variable, got reserved word while"},
+		{`let break = 1`, "expected variable, got reserved word break"},
+		{`let continue = 1`, "expected variable, got reserved word continue"},
+	}
+
+	for i, tt := range tests {
+		_, errs := New(lexer.New(tt.program)).ParseFSA()
+		if len(errs) == 0 {
+			t.Errorf("test[%d] - %q parsed without errors, expected %q", i, tt.program, tt.err)
+			continue
+		}
+		if !strings.Contains(strings.Join(errs, "\n"), tt.err) {
+			t.Errorf("test[%d] - %q errors wrong. expected %q, got %v", i, tt.program, tt.err, errs)
+		}
+	}
+}
+
+func TestKeywordLabels(t *testing.T) {
+	tests := []struct {
+		program string
+		states  []string
+	}{
+		{`exit: { println "a" -> main }`, []string{"exit"}},
+		{`main: -> exit exit: { println "a" -> main }`, []string{"main", "exit"}},
+		{`main: -> seek seek: { println "a" -> main }`, []string{"main", "seek"}},
+		{`main: -> mark mark: { println "a" -> main }`, []string{"main", "mark"}},
+		{`main: -> goto goto: { println "a" -> main }`, []string{"main", "goto"}},
+		{`main: -> write write: { println "a" -> main }`, []string{"main", "write"}},
+		{`main: -> insert insert: { println "a" -> main }`, []string{"main", "insert"}},
+		{`main: -> delete delete: { println "a" -> main }`, []string{"main", "delete"}},
+		{`main: -> for for: { println "a" -> main }`, []string{"main", "for"}},
+		{`main: -> while while: { println "a" -> main }`, []string{"main", "while"}},
+		{`main: -> break break: { println "a" -> main }`, []string{"main", "break"}},
+		{`main: -> continue continue: { println "a" -> main }`, []string{"main", "continue"}},
+	}
+
+	for i, tt := range tests {
+		fsa, errs := New(lexer.New(tt.program)).ParseFSA()
+		if len(errs) > 0 {
+			t.Fatalf("test[%d] - %q parse errors: %v", i, tt.program, errs)
+		}
+		var states []string
+		for _, stmt := range fsa.Statements {
+			if s, ok := stmt.(*ast.StateStatement); ok {
+				states = append(states, s.StateName)
+			}
+		}
+		if strings.Join(states, ",") != strings.Join(tt.states, ",") {
+			t.Errorf("test[%d] - states wrong. expected=%v, got=%v", i, tt.states, states)
+		}
+	}
+}
+
+func TestReservedWordVariable(t *testing.T) {
+	_, errs := New(lexer.New(`let exit = 1 println exit`)).ParseFSA()
+	if len(errs) != 1 {
+		t.Fatalf("expected one error for the reserved word, got %v", errs)
+	}
+}
+
+func TestExtents(t *testing.T) {
+	tests := []struct {
+		program string
+		action  [2]string // first and last literal of the state's action
+		value   [2]string // first and last literal of the assigned expression
+	}{
+		{`let x = 1 + 22 * y`, [2]string{"let", "y"}, [2]string{"1", "y"}},
+		{`let x = (a - b) .. f(c, "d")`, [2]string{"let", ")"}, [2]string{"(", ")"}},
+		{`let x[k] = [1, 2]`, [2]string{"let", "]"}, [2]string{"[", "]"}},
+	}
+
+	for i, tt := range tests {
+		fsa, errs := New(lexer.New(tt.program)).ParseFSA()
+		if len(errs) > 0 {
+			t.Fatalf("test[%d] - parse errors: %v", i, errs)
+		}
+		action := fsa.Statements[0].(*ast.StateStatement).Action
+		got := action.Extent()
+		if got.First.Literal != tt.action[0] || got.Last.Literal != tt.action[1] {
+			t.Errorf("test[%d] - action spans %q to %q, want %q to %q", i, got.First.Literal, got.Last.Literal, tt.action[0], tt.action[1])
+		}
+		got = action.(*ast.AssignAction).Expression.Extent()
+		if got.First.Literal != tt.value[0] || got.Last.Literal != tt.value[1] {
+			t.Errorf("test[%d] - value spans %q to %q, want %q to %q", i, got.First.Literal, got.Last.Literal, tt.value[0], tt.value[1])
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package runner
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ahalbert/ted/ted/ast"
+)
+
+// builtinFunc is a function built into ted. It is given the arguments as
+// written, so it can decide how to evaluate them.
+type builtinFunc func(r *Runner, call *ast.CallExpression) ast.Expression
+
+// builtins are the functions any program can call without defining them.
+// A function the program defines with the same name is called instead.
+var builtins map[string]builtinFunc
+
+func init() {
+	builtins = map[string]builtinFunc{
+		"len":     builtinLen,
+		"substr":  builtinSubstr,
+		"upper":   stringFunc(strings.ToUpper),
+		"lower":   stringFunc(strings.ToLower),
+		"trim":    stringFunc(strings.TrimSpace),
+		"split":   builtinSplit,
+		"join":    builtinJoin,
+		"index":   builtinIndex,
+		"replace": builtinReplace,
+		"sprintf": builtinSprintf,
+		"abs":     builtinAbs,
+		"min":     builtinMinMax(-1),
+		"max":     builtinMinMax(1),
+		"int":     builtinInt,
+		"num":     builtinNum,
+		"str":     builtinStr,
+		"match":   builtinMatch,
+		"gsub":    builtinGsub,
+	}
+}
+
+// IsBuiltin reports whether name is a function built into ted.
+func IsBuiltin(name string) bool {
+	_, ok := builtins[name]
+	return ok
+}
+
+// checkArgCount reports whether call has between min and max arguments.
+// A max of -1 means there is no most.
+func (r *Runner) checkArgCount(call *ast.CallExpression, min int, max int) bool {
+	n := len(call.Arguments)
+	if n >= min && (max < 0 || n <= max) {
+		return true
+	}
+	name := call.Function.String()
+	var msg string
+	switch {
+	case min == max:
+		msg = fmt.Sprintf("%s expects %d arguments, got %d", name, min, n)
+	case max < 0:
+		msg = fmt.Sprintf("%s expects at least %d arguments, got %d", name, min, n)
+	default:
+		msg = fmt.Sprintf("%s expects %d to %d arguments, got %d", name, min, max, n)
+	}
+	r.fatalError(msg, &ast.ExpressionAction{Expression: call})
+	return false
+}
+
+// evaluateArgs checks the number of arguments to call, and evaluates them.
+func (r *Runner) evaluateArgs(call *ast.CallExpression, min int, max int) ([]ast.Expression, bool) {
+	if !r.checkArgCount(call, min, max) {
+		return nil, false
+	}
+	args := make([]ast.Expression, 0, len(call.Arguments))
+	for _, arg := range call.Arguments {
+		val := r.evaluateExpression(arg)
+		if val == nil {
+			r.fatalError("argument "+arg.String()+" did not produce a value", &ast.ExpressionAction{Expression: call})
+			return nil, false
+		}
+		args = append(args, val)
+	}
+	return args, true
+}
+
+func (r *Runner) stringArg(call *ast.CallExpression, val ast.Expression) (string, bool) {
+	if isCollection(val) {
+		r.fatalError(call.Function.String()+" expects a string, got a list or map", &ast.ExpressionAction{Expression: call})
+		return "", false
+	}
+	return val.String(), true
+}
+
+func (r *Runner) numberArg(call *ast.CallExpression, val ast.Expression) (number, bool) {
+	n, err := toNumber(val)
+	if err != nil {
+		r.fatalError(fmt.Sprintf("%s expects a number, %v", call.Function.String(), err), &ast.ExpressionAction{Expression: call})
+		return number{}, false
+	}
+	return n, true
+}
+
+// intArg converts val to an int, dropping any fractional part.
+func (r *Runner) intArg(call *ast.CallExpression, val ast.Expression) (int, bool) {
+	n, ok := r.numberArg(call, val)
+	if !ok {
+		return 0, false
+	}
+	i, ok := n.truncate()
+	if !ok {
+		r.fatalError(fmt.Sprintf("%s expects an int, %s is out of range for one", call.Function.String(), val.String()), &ast.ExpressionAction{Expression: call})
+	}
+	return i, ok
+}
+
+// regexArg compiles val, which is usually a /regex/ literal, as a regex.
+func (r *Runner) regexArg(call *ast.CallExpression, val ast.Expression) (*regexp.Regexp, bool) {
+	rule, ok := r.stringArg(call, val)
+	if !ok {
+		return nil, false
+	}
+	re, err := r.compileRegex(rule)
+	if err != nil {
+		r.fatalError("regexp error, supplied: "+rule, &ast.ExpressionAction{Expression: call})
+		return nil, false
+	}
+	return re, true
+}
+
+// stringFunc makes a builtin of a function from one string to another.
+func stringFunc(fn func(string) string) builtinFunc {
+	return func(r *Runner, call *ast.CallExpression) ast.Expression {
+		args, ok := r.evaluateArgs(call, 1, 1)
+		if !ok {
+			return nil
+		}
+		s, ok := r.stringArg(call, args[0])
+		if !ok {
+			return nil
+		}
+		return &ast.StringLiteral{Value: fn(s)}
+	}
+}
+
+// len(x) is the number of elements in a list, keys in a map, or characters
+// in a string. A variable that isn't set has a length of 0.
+func builtinLen(r *Runner, call *ast.CallExpression) ast.Expression {
+	if !r.checkArgCount(call, 1, 1) {
+		return nil
+	}
+	switch val := r.evaluateContainer(call.Arguments[0]).(type) {
+	case *ast.ListLiteral:
+		return &ast.IntegerLiteral{Value: len(val.Elements)}
+	case *ast.Map:
+		return &ast.IntegerLiteral{Value: len(val.Keys)}
+	case nil:
+		return nil
+	default:
+		return &ast.IntegerLiteral{Value: utf8.RuneCountInString(val.String())}
+	}
+}
+
+// substr(s, start, length) is length characters of s from start, which
+// counts from 0, or the rest of s if length isn't given. The part of it
+// that lies outside of s is left out.
+func builtinSubstr(r *Runner, call *ast.CallExpression) ast.Expression {
+	args, ok := r.evaluateArgs(call, 2, 3)
+	if !ok {
+		return nil
+	}
+	s, ok := r.stringArg(call, args[0])
+	if !ok {
+		return nil
+	}
+	start, ok := r.intArg(call, args[1])
+	if !ok {
+		return nil
+	}
+	runes := []rune(s)
+	end := len(runes)
+	if len(args) == 3 {
+		length, ok := r.intArg(call, args[2])
+		if !ok {
+			return nil
+		}
+		end = min(end, max(start, start+length))
+	}
+	start = min(max(start, 0), len(runes))
+	end = max(end, start)
+	return &ast.StringLiteral{Value: string(runes[start:end])}
+}
+
+// split(s, sep) splits s into a list on each sep, or on runs of spaces if
+// sep isn't given.
+func builtinSplit(r *Runner, call *ast.CallExpression) ast.Expression {
+	args, ok := r.evaluateArgs(call, 1, 2)
+	if !ok {
+		return nil
+	}
+	s, ok := r.stringArg(call, args[0])
+	if !ok {
+		return nil
+	}
+	var parts []string
+	if len(args) == 1 {
+		parts = strings.Fields(s)
+	} else if sep, ok := r.stringArg(call, args[1]); !ok {
+		return nil
+	} else if s != "" {
+		parts = strings.Split(s, sep)
+	}
+	list := &ast.ListLiteral{Elements: make([]ast.Expression, 0, len(parts))}
+	for _, part := range parts {
+		list.Elements = append(list.Elements, &ast.StringLiteral{Value: part})
+	}
+	return list
+}
+
+// join(l, sep) joins the elements of a list, or the keys of a map, with sep
+// between them, or nothing if sep isn't given.
+func builtinJoin(r *Runner, call *ast.CallExpression) ast.Expression {
+	if !r.checkArgCount(call, 1, 2) {
+		return nil
+	}
+	c := r.collection(call.Arguments[0])
+	if c == nil {
+		return nil
+	}
+	sep := ""
+	if len(call.Arguments) == 2 {
+		val := r.evaluateExpression(call.Arguments[1])
+		if val == nil {
+			return nil
+		}
+		var ok bool
+		if sep, ok = r.stringArg(call, val); !ok {
+			return nil
+		}
+	}
+	var items []string
+	switch c := c.(type) {
+	case *ast.ListLiteral:
+		for _, e := range c.Elements {
+			items = append(items, e.String())
+		}
+	case *ast.Map:
+		items = c.Keys
+	}
+	return &ast.StringLiteral{Value: strings.Join(items, sep)}
+}
+
+// index(s, x) is the position of the first x in the string s, counting
+// characters from 0, or of the element x in the list s. It is -1 if x isn't
+// there.
+func builtinIndex(r *Runner, call *ast.CallExpression) ast.Expression {
+	if !r.checkArgCount(call, 2, 2) {
+		return nil
+	}
+	container := r.evaluateContainer(call.Arguments[0])
+	x := r.evaluateExpression(call.Arguments[1])
+	if container == nil || x == nil {
+		return nil
+	}
+	sub, ok := r.stringArg(call, x)
+	if !ok {
+		return nil
+	}
+	switch c := container.(type) {
+	case *ast.ListLiteral:
+		return &ast.IntegerLiteral{Value: slices.IndexFunc(c.Elements, func(e ast.Expression) bool { return e.String() == sub })}
+	case *ast.Map:
+		r.fatalError("index expects a string or list, use in to look for a key in a map", &ast.ExpressionAction{Expression: call})
+		return nil
+	}
+	s := container.String()
+	i := strings.Index(s, sub)
+	if i < 0 {
+		return &ast.IntegerLiteral{Value: -1}
+	}
+	return &ast.IntegerLiteral{Value: utf8.RuneCountInString(s[:i])}
+}
+
+// replace(s, old, new) replaces every old in s with new.
+func builtinReplace(r *Runner, call *ast.CallExpression) ast.Expression {
+	args, ok := r.evaluateArgs(call, 3, 3)
+	if !ok {
+		return nil
+	}
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		if strs[i], ok = r.stringArg(call, arg); !ok {
+			return nil
+		}
+	}
+	return &ast.StringLiteral{Value: strings.ReplaceAll(strs[0], strs[1], strs[2])}
+}
+
+// sprintf(format, args...) formats its arguments like Go's fmt.Sprintf.
+// Arguments for %d, %x, %o, %b and %c are converted to whole numbers, for
+// %f, %e and %g to numbers, and for anything else to strings.
+func builtinSprintf(r *Runner, call *ast.CallExpression) ast.Expression {
+	args, ok := r.evaluateArgs(call, 1, -1)
+	if !ok {
+		return nil
+	}
+	format, ok := r.stringArg(call, args[0])
+	if !ok {
+		return nil
+	}
+	verbs := formatVerbs(format)
+	if len(verbs) != len(args)-1 {
+		r.fatalError(fmt.Sprintf("sprintf format %q expects %d arguments, got %d", format, len(verbs), len(args)-1), &ast.ExpressionAction{Expression: call})
+		return nil
+	}
+	values := make([]any, len(verbs))
+	for i, verb := range verbs {
+		arg := args[i+1]
+		switch verb {
+		case 'd', 'x', 'X', 'o', 'b', 'c', '*':
+			values[i], ok = r.intArg(call, arg)
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			var n number
+			n, ok = r.numberArg(call, arg)
+			values[i] = n.float()
+		default:
+			values[i], ok = r.stringArg(call, arg)
+		}
+		if !ok {
+			return nil
+		}
+	}
+	return &ast.StringLiteral{Value: fmt.Sprintf(format, values...)}
+}
+
+// formatVerbs returns the verb of each argument format uses, with * for a
+// width or precision given as an argument.
+func formatVerbs(format string) []rune {
+	var verbs []rune
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		for i++; i < len(format) && strings.IndexByte("+-# 0123456789.*", format[i]) >= 0; i++ {
+			if format[i] == '*' {
+				verbs = append(verbs, '*')
+			}
+		}
+		if i < len(format) && format[i] != '%' {
+			verb, _ := utf8.DecodeRuneInString(format[i:])
+			verbs = append(verbs, verb)
+		}
+	}
+	return verbs
+}
+
+// abs(x) is x without its sign.
+func builtinAbs(r *Runner, call *ast.CallExpression) ast.Expression {
+	args, ok := r.evaluateArgs(call, 1, 1)
+	if !ok {
+		return nil
+	}
+	n, ok := r.numberArg(call, args[0])
+	if !ok {
+		return nil
+	}
+	if !n.isFloat && n.i != math.MinInt {
+		return r.numberValue(number{i: max(n.i, -n.i)})
+	}
+	return r.numberValue(number{f: math.Abs(n.float()), isFloat: true})
+}
+
+// builtinMinMax makes min(x, y, ...), when want is -1, and max(x, y, ...),
+// when it is 1.
+func builtinMinMax(want int) builtinFunc {
+	return func(r *Runner, call *ast.CallExpression) ast.Expression {
+		args, ok := r.evaluateArgs(call, 1, -1)
+		if !ok {
+			return nil
+		}
+		var best number
+		for i, arg := range args {
+			n, ok := r.numberArg(call, arg)
+			if !ok {
+				return nil
+			}
+			if i == 0 || compareNumbers(n, best) == want {
+				best = n
+			}
+		}
+		return r.numberValue(best)
+	}
+}
+
+// int(x) is x as a whole number, dropping any fractional part.
+func builtinInt(r *Runner, call *ast.CallExpression) ast.Expression {
+	args, ok := r.evaluateArgs(call, 1, 1)
+	if !ok {
+		return nil
+	}
+	i, ok := r.intArg(call, args[0])
+	if !ok {
+		return nil
+	}
+	return &ast.IntegerLiteral{Value: i}
+}
+
+// num(x) is x converted to a number the way arithmetic converts it, so
+// num("12.5ms") is 12.5.
+func builtinNum(r *Runner, call *ast.CallExpression) ast.Expression {
+	args, ok := r.evaluateArgs(call, 1, 1)
+	if !ok {
+		return nil
+	}
+	n, ok := r.numberArg(call, args[0])
+	if !ok {
+		return nil
+	}
+	return r.numberValue(n)
+}
+
+// str(x) is x converted to a string, the way it would be printed.
+func builtinStr(r *Runner, call *ast.CallExpression) ast.Expression {
+	args, ok := r.evaluateArgs(call, 1, 1)
+	if !ok {
+		return nil
+	}
+	s, ok := r.stringArg(call, args[0])
+	if !ok {
+		return nil
+	}
+	return &ast.StringLiteral{Value: s}
+}
+
+// match(s, /regex/) is the position of the first match of regex in s,
+// counting characters from 0, or -1 if there isn't one. Like a /regex/
+// action, a match sets $0..$N.
+func builtinMatch(r *Runner, call *ast.CallExpression) ast.Expression {
+	args, ok := r.evaluateArgs(call, 2, 2)
+	if !ok {
+		return nil
+	}
+	s, ok := r.stringArg(call, args[0])
+	if !ok {
+		return nil
+	}
+	re, ok := r.regexArg(call, args[1])
+	if !ok {
+		return nil
+	}
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return &ast.IntegerLiteral{Value: -1}
+	}
+	matches := make([]string, len(loc)/2)
+	for i := range matches {
+		if loc[2*i] >= 0 {
+			matches[i] = s[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	r.setCaptureGroups(matches)
+	return &ast.IntegerLiteral{Value: utf8.RuneCountInString(s[:loc[0]])}
+}
+
+// gsub(s, /regex/, replacement) replaces every match of regex in s. In the
+// replacement, $1 or ${1} is the text of the first capture group.
+func builtinGsub(r *Runner, call *ast.CallExpression) ast.Expression {
+	args, ok := r.evaluateArgs(call, 3, 3)
+	if !ok {
+		return nil
+	}
+	s, ok := r.stringArg(call, args[0])
+	if !ok {
+		return nil
+	}
+	re, ok := r.regexArg(call, args[1])
+	if !ok {
+		return nil
+	}
+	replacement, ok := r.stringArg(call, args[2])
+	if !ok {
+		return nil
+	}
+	return &ast.StringLiteral{Value: re.ReplaceAllString(s, replacement)}
+}
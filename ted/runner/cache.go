@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/rwtodd/Go.Sed/sed"
+)
+
+// maxCacheEntries bounds each cache, since rules built from templates can
+// produce a different string for every record.
+const maxCacheEntries = 4096
+
+// compileCache holds the compiled form of regexes, sed commands and
+// templates so that static rules are only compiled once per run.
+type compileCache struct {
+	regexps   map[string]*regexp.Regexp
+	engines   map[string]*sed.Engine
+	templates map[string]*template.Template
+}
+
+func newCompileCache() *compileCache {
+	return &compileCache{
+		regexps:   make(map[string]*regexp.Regexp),
+		engines:   make(map[string]*sed.Engine),
+		templates: make(map[string]*template.Template),
+	}
+}
+
+func (r *Runner) compileCache() *compileCache {
+	if r.cache == nil {
+		r.cache = newCompileCache()
+	}
+	return r.cache
+}
+
+func (r *Runner) compileRegex(rule string) (*regexp.Regexp, error) {
+	cache := r.compileCache()
+	if re, ok := cache.regexps[rule]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(rule)
+	if err != nil {
+		return nil, err
+	}
+	if len(cache.regexps) >= maxCacheEntries {
+		clear(cache.regexps)
+	}
+	cache.regexps[rule] = re
+	return re, nil
+}
+
+// compileSed returns an engine for command. Engines for commands that may
+// contain an address range are never reused, because the range remembers
+// whether it is active between runs.
+func (r *Runner) compileSed(command string) (*sed.Engine, error) {
+	if strings.Contains(command, ",") {
+		return sed.New(strings.NewReader(command))
+	}
+	cache := r.compileCache()
+	if engine, ok := cache.engines[command]; ok {
+		return engine, nil
+	}
+	engine, err := sed.New(strings.NewReader(command))
+	if err != nil {
+		return nil, err
+	}
+	if len(cache.engines) >= maxCacheEntries {
+		clear(cache.engines)
+	}
+	cache.engines[command] = engine
+	return engine, nil
+}
+
+func (r *Runner) compileTemplate(input string) (*template.Template, error) {
+	cache := r.compileCache()
+	if t, ok := cache.templates[input]; ok {
+		return t, nil
+	}
+	t, err := template.New("").Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(cache.templates) >= maxCacheEntries {
+		clear(cache.templates)
+	}
+	cache.templates[input] = t
+	return t, nil
+}
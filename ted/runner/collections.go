@@ -0,0 +1,295 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/ahalbert/ted/ted/ast"
+)
+
+// Lists and maps are kept in Runner.Collections rather than Variables,
+// which only holds strings. They are global: function parameters are always
+// strings, and hide a list or map of the same name. Elements are strings
+// too, so a list or map can't hold another one.
+
+// isCollection reports whether value is a list or a map.
+func isCollection(value ast.Expression) bool {
+	switch value.(type) {
+	case *ast.ListLiteral, *ast.Map:
+		return true
+	}
+	return false
+}
+
+func newMap() *ast.Map {
+	return &ast.Map{Values: make(map[string]ast.Expression)}
+}
+
+// copyCollection copies a list or map, so assigning one variable to another
+// doesn't make them share elements.
+func copyCollection(value ast.Expression) ast.Expression {
+	switch c := value.(type) {
+	case *ast.ListLiteral:
+		return &ast.ListLiteral{Elements: slices.Clone(c.Elements)}
+	case *ast.Map:
+		m := newMap()
+		m.Keys = slices.Clone(c.Keys)
+		for k, v := range c.Values {
+			m.Values[k] = v
+		}
+		return m
+	}
+	return value
+}
+
+// lookupCollection returns the list or map called name, if there is one.
+func (r *Runner) lookupCollection(name string) (ast.Expression, bool) {
+	if frame := r.currentFrame(); frame != nil {
+		if _, ok := frame[name]; ok {
+			return nil, false
+		}
+	}
+	c, ok := r.Collections[name]
+	return c, ok
+}
+
+// setCollection sets name to the list or map value, replacing any string
+// it held.
+func (r *Runner) setCollection(name string, value ast.Expression) bool {
+	if frame := r.currentFrame(); frame != nil {
+		if _, ok := frame[name]; ok {
+			r.fatalError("can't set the parameter "+name+" to a list or map", nil)
+			return false
+		}
+	}
+	delete(r.Variables, name)
+	r.Collections[name] = value
+	return true
+}
+
+// evaluateContainer evaluates expr where a list or map is expected. A
+// variable that isn't set at all is an empty map, so that counts[$1] can be
+// read before anything has been counted.
+func (r *Runner) evaluateContainer(expr ast.Expression) ast.Expression {
+	if ident, ok := expr.(*ast.Identifier); ok {
+		if c, ok := r.lookupCollection(ident.Value); ok {
+			return c
+		}
+		if _, ok := r.scopeFor(ident.Value)[ident.Value]; !ok && !isFieldName(ident.Value) {
+			return newMap()
+		}
+	}
+	return r.evaluateExpression(expr)
+}
+
+// collection is evaluateContainer for places only a list or map will do.
+// An empty string, such as a variable that has been cleared, is an empty
+// map.
+func (r *Runner) collection(expr ast.Expression) ast.Expression {
+	val := r.evaluateContainer(expr)
+	if isCollection(val) {
+		return val
+	}
+	if s, ok := val.(*ast.StringLiteral); ok && s.Value == "" {
+		return newMap()
+	}
+	if val != nil {
+		r.fatalError(expr.String()+" is not a list or map", &ast.ExpressionAction{Expression: expr})
+	}
+	return nil
+}
+
+// element converts value to be stored in a list or map.
+func (r *Runner) element(value ast.Expression, expr ast.Expression) (ast.Expression, bool) {
+	if value == nil {
+		r.fatalError("expression did not produce a value", &ast.ExpressionAction{Expression: expr})
+		return nil, false
+	}
+	if isCollection(value) {
+		r.fatalError("a list or map can't hold another list or map", &ast.ExpressionAction{Expression: expr})
+		return nil, false
+	}
+	return &ast.StringLiteral{Value: value.String()}, true
+}
+
+func (r *Runner) evaluateListLiteral(list *ast.ListLiteral) ast.Expression {
+	out := &ast.ListLiteral{Token: list.Token, Elements: make([]ast.Expression, 0, len(list.Elements))}
+	for _, e := range list.Elements {
+		val, ok := r.element(r.evaluateExpression(e), e)
+		if !ok {
+			return nil
+		}
+		out.Elements = append(out.Elements, val)
+	}
+	return out
+}
+
+func (r *Runner) evaluateMapLiteral(lit *ast.MapLiteral) ast.Expression {
+	m := newMap()
+	m.Token = lit.Token
+	for i, k := range lit.Keys {
+		key, ok := r.element(r.evaluateExpression(k), k)
+		if !ok {
+			return nil
+		}
+		val, ok := r.element(r.evaluateExpression(lit.Values[i]), lit.Values[i])
+		if !ok {
+			return nil
+		}
+		setKey(m, key.String(), val)
+	}
+	return m
+}
+
+func setKey(m *ast.Map, key string, value ast.Expression) {
+	if _, ok := m.Values[key]; !ok {
+		m.Keys = append(m.Keys, key)
+	}
+	m.Values[key] = value
+}
+
+// listIndex converts index to a position in list. If appending is true,
+// one past the last element is allowed too.
+func (r *Runner) listIndex(list *ast.ListLiteral, index ast.Expression, expr ast.Expression, appending bool) (int, bool) {
+	i, err := r.convertToInt(index)
+	if errors.Is(err, errIntRange) {
+		r.fatalError(fmt.Sprintf("list index %s out of range, the list has %d elements", index.String(), len(list.Elements)), &ast.ExpressionAction{Expression: expr})
+		return 0, false
+	}
+	if err != nil {
+		r.fatalError("list index must be an integer, got "+strconv.Quote(index.String()), &ast.ExpressionAction{Expression: expr})
+		return 0, false
+	}
+	end := len(list.Elements)
+	if appending {
+		end++
+	}
+	if i < 0 || i >= end {
+		r.fatalError(fmt.Sprintf("list index %d out of range, the list has %d elements", i, len(list.Elements)), &ast.ExpressionAction{Expression: expr})
+		return 0, false
+	}
+	return i, true
+}
+
+// evaluateIndexExpression returns an element of a list, or the value of a
+// key in a map. A key that isn't in the map is empty.
+func (r *Runner) evaluateIndexExpression(expression *ast.IndexExpression) ast.Expression {
+	c := r.collection(expression.Left)
+	if c == nil {
+		return nil
+	}
+	index := r.evaluateExpression(expression.Index)
+	if index == nil {
+		r.fatalError("index did not produce a value", &ast.ExpressionAction{Expression: expression})
+		return nil
+	}
+	switch c := c.(type) {
+	case *ast.Map:
+		if val, ok := c.Values[index.String()]; ok {
+			return val
+		}
+		return &ast.StringLiteral{Value: ""}
+	case *ast.ListLiteral:
+		i, ok := r.listIndex(c, index, expression, false)
+		if !ok {
+			return nil
+		}
+		return c.Elements[i]
+	}
+	return nil
+}
+
+// evaluateIn reports whether left is a key of the map, or an element of the
+// list, that right evaluates to.
+func (r *Runner) evaluateIn(expression *ast.InfixExpression) ast.Expression {
+	left := r.evaluateExpression(expression.Left)
+	right := r.collection(expression.Right)
+	if left == nil || right == nil {
+		return nil
+	}
+	switch c := right.(type) {
+	case *ast.Map:
+		_, ok := c.Values[left.String()]
+		return &ast.Boolean{Value: ok}
+	case *ast.ListLiteral:
+		for _, e := range c.Elements {
+			if e.String() == left.String() {
+				return &ast.Boolean{Value: true}
+			}
+		}
+	}
+	return &ast.Boolean{Value: false}
+}
+
+// doAssignElement sets a key of a map, or an element of a list. Setting the
+// element one past the end of a list appends to it, and setting a key of a
+// variable that isn't set, or is empty, makes it a map.
+func (r *Runner) doAssignElement(action *ast.AssignAction, value ast.Expression) {
+	val, ok := r.element(value, action.Expression)
+	if !ok {
+		return
+	}
+	c, ok := r.lookupCollection(action.Target)
+	if !ok {
+		if current, set := r.scopeFor(action.Target)[action.Target]; set && current != "" {
+			r.fatalError(action.Target+" is not a list or map", action)
+			return
+		}
+		c = newMap()
+		if !r.setCollection(action.Target, c) {
+			return
+		}
+	}
+	index := r.evaluateExpression(action.Index)
+	if index == nil {
+		r.fatalError("index did not produce a value", action)
+		return
+	}
+	switch c := c.(type) {
+	case *ast.Map:
+		setKey(c, index.String(), val)
+	case *ast.ListLiteral:
+		i, ok := r.listIndex(c, index, action.Index, true)
+		if !ok {
+			return
+		}
+		if i == len(c.Elements) {
+			c.Elements = append(c.Elements, val)
+		} else {
+			c.Elements[i] = val
+		}
+	}
+}
+
+// doDeleteElementAction removes a key from a map, or an element from a
+// list. Deleting a key that isn't there does nothing.
+func (r *Runner) doDeleteElementAction(action *ast.DeleteElementAction) {
+	index := r.evaluateExpression(action.Index)
+	if index == nil {
+		r.fatalError("index did not produce a value", action)
+		return
+	}
+	c, ok := r.lookupCollection(action.Variable)
+	if !ok {
+		if current, set := r.scopeFor(action.Variable)[action.Variable]; set && current != "" {
+			r.fatalError(action.Variable+" is not a list or map", action)
+		}
+		return
+	}
+	switch c := c.(type) {
+	case *ast.Map:
+		key := index.String()
+		if _, ok := c.Values[key]; ok {
+			delete(c.Values, key)
+			c.Keys = slices.DeleteFunc(c.Keys, func(k string) bool { return k == key })
+		}
+	case *ast.ListLiteral:
+		i, ok := r.listIndex(c, index, action.Index, false)
+		if !ok {
+			return
+		}
+		c.Elements = slices.Delete(c.Elements, i, i+1)
+	}
+}
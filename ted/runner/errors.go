@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/ahalbert/ted/ted/ast"
+	"github.com/ahalbert/ted/ted/token"
+)
+
+// RuntimeError is returned by RunFSA when the program fails while running.
+type RuntimeError struct {
+	State   string
+	Message string
+	Pos     token.Token // LineNum is 0 if the error has no position in the program
+	Record  int         // 1-based record number, 0 if no record was being processed
+	Input   string
+	text    string
+}
+
+func (e *RuntimeError) Error() string {
+	return strings.TrimSuffix(e.text, "\n")
+}
+
+// IOError is returned by RunFSA when reading input or writing output fails.
+type IOError struct {
+	Name string
+	Err  error
+}
+
+func (e *IOError) Error() string {
+	if e.Name == "" {
+		return e.Err.Error()
+	}
+	return e.Name + ": " + e.Err.Error()
+}
+
+func (e *IOError) Unwrap() error {
+	return e.Err
+}
+
+// ExitError is returned by RunFSA when the program runs exit with a
+// non-zero status.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// newRuntimeError builds the error for msg raised by action.
+func (r *Runner) newRuntimeError(msg string, action ast.Action) *RuntimeError {
+	err := &RuntimeError{State: r.CurrState, Message: msg, Input: r.InputName, text: r.formatError(msg, action)}
+	if action != nil {
+		err.Pos = action.Pos()
+	}
+	if r.Tape != nil && r.Tape.Offset() >= 0 && r.CurrState != "END" {
+		err.Record = r.Tape.Offset() + 1
+	}
+	return err
+}
+
+// formatError describes a runtime error: where in the program it happened,
+// with the offending source line and a caret under the column, and which
+// input record was being processed.
+func (r *Runner) formatError(msg string, action ast.Action) string {
+	var out bytes.Buffer
+	out.WriteString("Runtime Error in state: " + r.CurrState + "\n")
+
+	if action != nil && action.Pos().LineNum > 0 {
+		tok := action.Pos()
+		name := r.ProgramName
+		if name == "" {
+			name = "<program>"
+		}
+		out.WriteString(fmt.Sprintf("%s:%d:%d: %s\n", name, tok.LineNum, tok.Position, msg))
+		lines := strings.Split(r.Program, "\n")
+		if tok.LineNum <= len(lines) {
+			line := lines[tok.LineNum-1]
+			gutter := fmt.Sprintf("%5d | ", tok.LineNum)
+			out.WriteString(gutter + line + "\n")
+			out.WriteString(strings.Repeat(" ", len(gutter)-2) + "| " + underline(line, tok, action.Extent()) + "\n")
+		}
+	} else {
+		if action != nil {
+			out.WriteString("Action: " + action.String() + "\n")
+		}
+		out.WriteString(msg + "\n")
+	}
+
+	if r.Tape != nil && r.Tape.Offset() >= 0 && r.CurrState != "END" {
+		out.WriteString(fmt.Sprintf("while processing record %d", r.Tape.Offset()+1))
+		if r.InputName != "" {
+			out.WriteString(" of " + r.InputName)
+		}
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// underline marks the column of at in line with a caret, and the rest of
+// span that is on the same line with tildes.
+func underline(line string, at token.Token, span ast.Span) string {
+	from, to := at.Position, at.Position+1
+	if span.First.LineNum == at.LineNum && span.First.Position < at.Position {
+		from = span.First.Position
+	}
+	if span.Last.EndLineNum == at.LineNum && span.Last.EndPosition > to {
+		to = span.Last.EndPosition
+	} else if span.Last.EndLineNum > at.LineNum {
+		to = max(len(line)+1, to)
+	}
+	return caretPadding(line, from) + strings.Repeat("~", at.Position-from) + "^" + strings.Repeat("~", to-at.Position-1)
+}
+
+// caretPadding returns whitespace that lines a caret up with column of
+// line, keeping any tabs so the alignment survives tab expansion.
+func caretPadding(line string, column int) string {
+	var out bytes.Buffer
+	for i := 0; i < column-1 && i < len(line); i++ {
+		if line[i] == '\t' {
+			out.WriteByte('\t')
+		} else {
+			out.WriteByte(' ')
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,257 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Fields are split from $_ on $FS the first time one of $F1..$FN or $NF is
+// used in a cycle, rather than for every record, and are split again if $_
+// changes. Assigning to a field rebuilds $_ from the fields joined by $OFS.
+//
+// With a $FORMAT of csv or tsv, fields are the row's columns instead, $.name
+// is the column called name in the file's header, and $_ is rebuilt with
+// columns quoted where needed.
+type fieldCache struct {
+	fields []string
+	of     string // the $_ fields were split from
+	valid  bool
+}
+
+// lookupField returns the value of key if it is a field, a column or a JSON
+// path.
+func (r *Runner) lookupField(key string) (string, bool) {
+	if strings.HasPrefix(key, "$.") && r.getVariable("$FORMAT") == "jsonl" {
+		return r.getPath(key[len("$."):]), true
+	}
+	n, ok := r.fieldNumber(key)
+	if !ok {
+		return "", false
+	}
+	return r.getField(n), true
+}
+
+// assignField sets key if it is a field, a column or a JSON path, and
+// reports whether it was one.
+func (r *Runner) assignField(key string, value string) bool {
+	if strings.HasPrefix(key, "$.") && r.getVariable("$FORMAT") == "jsonl" {
+		r.setPath(key[len("$."):], value)
+		return true
+	}
+	n, ok := r.fieldNumber(key)
+	if !ok {
+		return false
+	}
+	r.setField(n, value)
+	return true
+}
+
+// fieldNumber reports whether key names a field, returning its number, or
+// 0 for $NF. A column that doesn't exist is a fatal error and -1.
+func (r *Runner) fieldNumber(key string) (int, bool) {
+	if key == "$NF" {
+		return 0, true
+	}
+	if strings.HasPrefix(key, "$.") {
+		return r.columnNumber(key[len("$."):]), true
+	}
+	if len(key) < 3 || key[:2] != "$F" || key[2] < '1' || key[2] > '9' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(key[2:])
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// isFieldName reports whether key names a field, a column or a JSON path,
+// without looking it up.
+func isFieldName(key string) bool {
+	if key == "$NF" || strings.HasPrefix(key, "$.") {
+		return true
+	}
+	return len(key) >= 3 && key[:2] == "$F" && key[2] >= '1' && key[2] <= '9'
+}
+
+func (r *Runner) columnNumber(name string) int {
+	if _, ok := r.csvComma(); !ok {
+		r.fatalError("$."+name+" names a column or path, which needs --format csv, tsv or jsonl", nil)
+		return -1
+	}
+	n, ok := r.header[name]
+	if !ok {
+		r.fatalError("there is no column named "+strconv.Quote(name)+" in the header", nil)
+		return -1
+	}
+	return n
+}
+
+// csvComma returns the column delimiter if $FORMAT is csv or tsv.
+func (r *Runner) csvComma() (rune, bool) {
+	switch r.getVariable("$FORMAT") {
+	case "csv":
+		return ',', true
+	case "tsv":
+		return '\t', true
+	}
+	return 0, false
+}
+
+// splitFields splits text on $FS. A $FS of a single space splits on runs of
+// whitespace and ignores it at either end; a $FSMODE of regex makes $FS a
+// regular expression.
+func (r *Runner) splitFields(text string) []string {
+	if comma, ok := r.csvComma(); ok {
+		return r.splitRow(text, comma)
+	}
+	fs := r.getVariable("$FS")
+	if fs == " " {
+		return strings.Fields(text)
+	}
+	if text == "" {
+		return nil
+	}
+	if r.getVariable("$FSMODE") == "regex" {
+		re, err := r.compileRegex(fs)
+		if err != nil {
+			r.fatalError("$FS is not a valid regex: "+err.Error(), nil)
+			return nil
+		}
+		return re.Split(text, -1)
+	}
+	return strings.Split(text, fs)
+}
+
+// splitRow splits a CSV or TSV row into its columns, unquoting them.
+func (r *Runner) splitRow(text string, comma rune) []string {
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = comma
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	fields, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	} else if err != nil {
+		r.fatalError("malformed row: "+err.Error(), nil)
+		return nil
+	}
+	return fields
+}
+
+// joinFields is the inverse of splitFields, joining fields with $OFS, or
+// quoting them as a CSV or TSV row.
+func (r *Runner) joinFields(fields []string) string {
+	comma, ok := r.csvComma()
+	if !ok {
+		return strings.Join(fields, r.getVariable("$OFS"))
+	}
+	var out bytes.Buffer
+	writer := csv.NewWriter(&out)
+	writer.Comma = comma
+	writer.Write(fields)
+	writer.Flush()
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// readHeader takes the first row of a CSV or TSV file off the tape as the
+// names of its columns, and prints it unchanged unless printing is off.
+func (r *Runner) readHeader() {
+	r.header = nil
+	r.clearAndSetVariable("$HEADER", "")
+	if !r.Tape.Next() {
+		return
+	}
+	text := r.Tape.Text()
+	r.header = make(map[string]int)
+	for i, name := range r.splitFields(text) {
+		if _, ok := r.header[name]; !ok {
+			r.header[name] = i + 1
+		}
+	}
+	r.clearAndSetVariable("$HEADER", text)
+	if r.getVariable("$PRINTMODE") == "print" {
+		if _, err := io.WriteString(r.OutputTape, text+r.Tape.Terminator()); err != nil {
+			r.ioError(err)
+		}
+	}
+	r.Tape.Delete()
+}
+
+func (r *Runner) currentFields() []string {
+	text := r.getVariable("$_")
+	if !r.fieldCache.valid || r.fieldCache.of != text {
+		r.fieldCache = fieldCache{fields: r.splitFields(text), of: text, valid: true}
+	}
+	return r.fieldCache.fields
+}
+
+// getField returns the value of the field n, or $NF if n is 0. Fields past
+// the last are empty.
+func (r *Runner) getField(n int) string {
+	if n < 0 {
+		return ""
+	}
+	fields := r.currentFields()
+	if n == 0 {
+		return strconv.Itoa(len(fields))
+	}
+	if n > len(fields) {
+		return ""
+	}
+	return fields[n-1]
+}
+
+// setField sets field n, adding empty fields if there are fewer than n, or
+// sets the number of fields if n is 0. $_ is then rebuilt from the fields.
+func (r *Runner) setField(n int, value string) {
+	if n < 0 {
+		return
+	}
+	fields := slices.Clone(r.currentFields())
+	if n == 0 {
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			r.fatalError("$NF must be set to a number of fields, got "+strconv.Quote(value), nil)
+			return
+		}
+		for len(fields) < count {
+			fields = append(fields, "")
+		}
+		fields = fields[:count]
+	} else {
+		for len(fields) < n {
+			fields = append(fields, "")
+		}
+		fields[n-1] = value
+	}
+	text := r.joinFields(fields)
+	r.scopeFor("$_")["$_"] = text
+	r.fieldCache = fieldCache{fields: fields, of: text, valid: true}
+}
+
+// withFields returns vars with the fields, columns or JSON paths added, for
+// templates that use them.
+func (r *Runner) withFields(vars map[string]string) map[string]string {
+	fields := r.currentFields()
+	out := make(map[string]string, len(vars)+len(fields)+1)
+	for k, v := range vars {
+		out[k] = v
+	}
+	out["$NF"] = strconv.Itoa(len(fields))
+	for i, field := range fields {
+		out["$F"+strconv.Itoa(i+1)] = field
+	}
+	for name, n := range r.header {
+		out["$."+name] = r.getField(n)
+	}
+	if r.getVariable("$FORMAT") == "jsonl" {
+		r.withPaths(out)
+	}
+	return out
+}
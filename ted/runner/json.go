@@ -0,0 +1,270 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// With a $FORMAT of jsonl each record is a JSON value, and $.a.b is the
+// value at that path in it, with numeric parts indexing arrays. Like fields,
+// the record is parsed from $_ the first time a path is used in a cycle, and
+// assigning to a path rebuilds $_ from the modified value.
+type jsonCache struct {
+	root  any
+	of    string // the $_ root was parsed from
+	valid bool
+}
+
+// jsonObject is a decoded JSON object that remembers the order of its keys,
+// so a modified record is written back in the order it was read.
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]any)}
+}
+
+func (o *jsonObject) set(key string, value any) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		k, err := marshalJSON(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := marshalJSON(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		out.Write(k)
+		out.WriteByte(':')
+		out.Write(v)
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+// marshalJSON is json.Marshal without escaping <, > and &, which would change
+// strings that were never touched.
+func marshalJSON(v any) ([]byte, error) {
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n")), nil
+}
+
+// parseJSON parses a record, keeping the order of object keys and numbers
+// as they were written. A blank record is nil.
+func parseJSON(text string) (any, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	root, err := decodeJSON(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("more than one value in the record")
+	}
+	return root, nil
+}
+
+func decodeJSON(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := newJSONObject()
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.set(key.(string), value)
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err := dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// jsonString is how a JSON value reads as a variable: strings without their
+// quotes, null as empty, and objects and arrays as JSON.
+func jsonString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	out, _ := marshalJSON(v)
+	return string(out)
+}
+
+// jsonValue converts value to keep the type of old, the value it replaces,
+// when it fits: numbers stay numbers and booleans stay booleans. Anything
+// else becomes a string.
+func jsonValue(old any, value string) any {
+	switch old.(type) {
+	case json.Number:
+		if _, err := strconv.ParseFloat(value, 64); err == nil && json.Valid([]byte(value)) {
+			return json.Number(value)
+		}
+	case bool:
+		if value == "true" || value == "false" {
+			return value == "true"
+		}
+	}
+	return value
+}
+
+func (r *Runner) currentJSON() any {
+	text := r.getVariable("$_")
+	if !r.jsonCache.valid || r.jsonCache.of != text {
+		root, err := parseJSON(text)
+		if err != nil {
+			r.fatalError("record is not valid JSON: "+err.Error(), nil)
+		}
+		r.jsonCache = jsonCache{root: root, of: text, valid: true}
+	}
+	return r.jsonCache.root
+}
+
+// getPath returns the value at path, or "" if there is nothing there.
+func (r *Runner) getPath(path string) string {
+	v := r.currentJSON()
+	for _, part := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case *jsonObject:
+			v = node.values[part]
+		case []any:
+			idx, err := strconv.Atoi(part)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return ""
+			}
+			v = node[idx]
+		default:
+			return ""
+		}
+	}
+	return jsonString(v)
+}
+
+// setPath sets the value at path, adding objects for any parts of it that
+// are missing, and rebuilds $_.
+func (r *Runner) setPath(path string, value string) {
+	root := r.currentJSON()
+	if r.DidFatalError {
+		return
+	}
+	if root == nil {
+		root = newJSONObject()
+	}
+	parts := strings.Split(path, ".")
+	v := root
+	for i, part := range parts {
+		last := i == len(parts)-1
+		switch node := v.(type) {
+		case *jsonObject:
+			if last {
+				node.set(part, jsonValue(node.values[part], value))
+			} else if next, ok := node.values[part]; ok && next != nil {
+				v = next
+			} else {
+				child := newJSONObject()
+				node.set(part, child)
+				v = child
+			}
+		case []any:
+			idx, err := strconv.Atoi(part)
+			if err != nil || idx < 0 || idx >= len(node) {
+				r.fatalError("can't set $."+path+": "+strconv.Quote(part)+" is not an index of the array", nil)
+				return
+			}
+			if last {
+				node[idx] = jsonValue(node[idx], value)
+			} else {
+				v = node[idx]
+			}
+		default:
+			where := "the record"
+			if i > 0 {
+				where = "$." + strings.Join(parts[:i], ".")
+			}
+			r.fatalError("can't set $."+path+": "+where+" is not an object or array", nil)
+			return
+		}
+	}
+
+	out, err := marshalJSON(root)
+	if err != nil {
+		r.fatalError("can't write the record as JSON: "+err.Error(), nil)
+		return
+	}
+	text := string(out)
+	r.scopeFor("$_")["$_"] = text
+	r.jsonCache = jsonCache{root: root, of: text, valid: true}
+}
+
+// withPaths adds every path in the record to vars, for templates.
+func (r *Runner) withPaths(vars map[string]string) {
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		if prefix != "$" {
+			vars[prefix] = jsonString(v)
+		}
+		switch node := v.(type) {
+		case *jsonObject:
+			for _, key := range node.keys {
+				walk(prefix+"."+key, node.values[key])
+			}
+		case []any:
+			for i, child := range node {
+				walk(prefix+"."+strconv.Itoa(i), child)
+			}
+		}
+	}
+	walk("$", r.currentJSON())
+}
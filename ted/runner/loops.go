@@ -0,0 +1,137 @@
+package runner
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/ahalbert/ted/ted/ast"
+)
+
+// DefaultLoopLimit is how many times a while loop may run before it is a
+// runtime error, unless $LOOPLIMIT is set.
+const DefaultLoopLimit = 1000000
+
+// Loops run inside a single cycle, so they never move the head. break and
+// continue set loopControl, which stops every block between them and the
+// loop, and the loop clears it.
+
+// loopStopped reports whether something other than break has stopped the
+// loop being run.
+func (r *Runner) loopStopped() bool {
+	return (r.ShouldHalt && r.CurrState != "END") || r.DidReturn || r.DidExit || r.DidFatalError
+}
+
+// doLoopBody runs one iteration of a loop, and reports whether to run
+// another.
+func (r *Runner) doLoopBody(action ast.Action) bool {
+	r.loopDepth++
+	r.doAction(action)
+	r.loopDepth--
+	control := r.loopControl
+	r.loopControl = ""
+	return control != "break" && !r.loopStopped()
+}
+
+func (r *Runner) loopLimit() (int, bool) {
+	limit, err := strconv.Atoi(r.getVariable("$LOOPLIMIT"))
+	if err != nil {
+		r.fatalError("$LOOPLIMIT must be a number, got "+strconv.Quote(r.getVariable("$LOOPLIMIT")), nil)
+		return 0, false
+	}
+	return limit, true
+}
+
+// doWhileAction runs the action for as long as the condition is true, up
+// to $LOOPLIMIT times, or without a limit if $LOOPLIMIT is 0.
+func (r *Runner) doWhileAction(action *ast.WhileAction) {
+	limit, ok := r.loopLimit()
+	if !ok {
+		return
+	}
+	for n := 0; !r.contextDone(); n++ {
+		cond, ok := r.evaluateExpression(action.Condition).(*ast.Boolean)
+		if !ok {
+			r.fatalError("type error expected bool in while condition", action)
+			return
+		}
+		if !cond.Value {
+			return
+		}
+		if limit > 0 && n == limit {
+			r.fatalError(fmt.Sprintf("while loop ran %d times, the most $LOOPLIMIT allows", limit), action)
+			return
+		}
+		if !r.doLoopBody(action.Action) {
+			return
+		}
+	}
+}
+
+// doForAction runs the action once for each key of a map, element of a list
+// or record of a string, in order. A string, such as a captured block, is
+// split into records the same way the input is. The loop is over what the
+// collection held when it started, so the action can change it.
+func (r *Runner) doForAction(action *ast.ForAction) {
+	var items []string
+	switch c := r.evaluateContainer(action.Collection).(type) {
+	case *ast.Map:
+		items = slices.Clone(c.Keys)
+	case *ast.ListLiteral:
+		for _, e := range c.Elements {
+			items = append(items, e.String())
+		}
+	case nil:
+		return
+	default:
+		items = r.records(c.String())
+	}
+	for _, item := range items {
+		r.clearAndSetVariable(action.Variable, item)
+		if !r.doLoopBody(action.Action) {
+			return
+		}
+	}
+}
+
+// records splits text into records. A seperator at the end doesn't make an
+// empty record after it.
+func (r *Runner) records(text string) []string {
+	if text == "" {
+		return nil
+	}
+	tape := NewStringTape(text)
+	if !r.splitTape(tape) {
+		return nil
+	}
+	var records []string
+	for tape.Next() {
+		records = append(records, tape.Text())
+	}
+	if len(records) > 1 && records[len(records)-1] == "" {
+		records = records[:len(records)-1]
+	}
+	return records
+}
+
+// checkPausedCycles counts a cycle run while paused, which is a runtime
+// error once there have been more in a row than $LOOPLIMIT allows, so that
+// a pause with no play doesn't hang ted.
+func (r *Runner) checkPausedCycles() {
+	limit, ok := r.loopLimit()
+	if !ok {
+		return
+	}
+	r.pausedCycles++
+	if limit > 0 && r.pausedCycles > limit {
+		r.fatalError(fmt.Sprintf("paused on the same record for %d cycles, the most $LOOPLIMIT allows", limit), nil)
+	}
+}
+
+func (r *Runner) doLoopControlAction(action *ast.LoopControlAction) {
+	if r.loopDepth == 0 {
+		r.fatalError(action.Command+" outside of a loop", action)
+		return
+	}
+	r.loopControl = action.Command
+}
@@ -0,0 +1,212 @@
+package runner
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ahalbert/ted/ted/ast"
+)
+
+// Numbers are ints while they are whole and fit in one, and become floats
+// when they don't, such as when a division isn't exact or a sum overflows.
+
+type number struct {
+	i       int
+	f       float64
+	isFloat bool
+}
+
+func (n number) float() float64 {
+	if n.isFloat {
+		return n.f
+	}
+	return float64(n.i)
+}
+
+// truncate converts n to an int, dropping any fractional part, and reports
+// false if it doesn't fit in one.
+func (n number) truncate() (int, bool) {
+	if !n.isFloat {
+		return n.i, true
+	}
+	f := math.Trunc(n.f)
+	if f < math.MinInt64 || f >= math.MaxInt64 || math.IsNaN(f) {
+		return 0, false
+	}
+	return int(f), true
+}
+
+var numberPrefix = regexp.MustCompile(`^[-+]?(\d+(\.\d*)?|\.\d+)([eE][-+]?\d+)?`)
+var unitSuffix = regexp.MustCompile(`^\pL*%?$`)
+
+// parseNumber converts s to a number. If units is true, the number may be
+// followed by a unit such as ms or %, which is ignored, so "12.5ms" is 12.5.
+func parseNumber(s string, units bool) (number, bool) {
+	prefix := numberPrefix.FindString(s)
+	if prefix == "" {
+		return number{}, false
+	}
+	if rest := s[len(prefix):]; rest != "" && (!units || !unitSuffix.MatchString(rest)) {
+		return number{}, false
+	}
+	if i, err := strconv.Atoi(prefix); err == nil {
+		return number{i: i}, true
+	}
+	f, err := strconv.ParseFloat(prefix, 64)
+	if err != nil {
+		return number{}, false
+	}
+	return number{f: f, isFloat: true}, true
+}
+
+// toNumber converts a value for arithmetic. Space around a string is
+// ignored, and the empty string, which is what a cleared variable holds,
+// is 0.
+func toNumber(value ast.Expression) (number, error) {
+	switch v := value.(type) {
+	case *ast.IntegerLiteral:
+		return number{i: v.Value}, nil
+	case *ast.FloatLiteral:
+		return number{f: v.Value, isFloat: true}, nil
+	case *ast.StringLiteral:
+		s := strings.TrimSpace(v.Value)
+		if s == "" {
+			return number{}, nil
+		}
+		if n, ok := parseNumber(s, true); ok {
+			return n, nil
+		}
+	}
+	return number{}, fmt.Errorf("%q is not a number", value.String())
+}
+
+// compareNumber converts a value for comparing as a number. Unlike
+// toNumber, a string only counts if it is a number and nothing else, so
+// "10ms" and "9ms" compare as strings.
+func compareNumber(value ast.Expression) (number, bool) {
+	switch v := value.(type) {
+	case *ast.IntegerLiteral:
+		return number{i: v.Value}, true
+	case *ast.FloatLiteral:
+		return number{f: v.Value, isFloat: true}, true
+	case *ast.StringLiteral:
+		return parseNumber(v.Value, false)
+	}
+	return number{}, false
+}
+
+// numberValue converts n back to a value. A float is printed with $OFMT.
+func (r *Runner) numberValue(n number) ast.Expression {
+	if !n.isFloat {
+		return &ast.IntegerLiteral{Value: n.i}
+	}
+	return &ast.FloatLiteral{Value: n.f, Format: r.getVariable("$OFMT")}
+}
+
+// evaluateArithmetic applies expression's operator to left and right, the
+// values of its operands. Errors point at the operator.
+func (r *Runner) evaluateArithmetic(left ast.Expression, right ast.Expression, expression *ast.InfixExpression) ast.Expression {
+	op := expression.Operator
+	at := &ast.ExpressionAction{Expression: expression}
+	l, err := toNumber(left)
+	if err != nil {
+		r.fatalError(fmt.Sprintf("%s expects numbers, %v", op, err), at)
+		return nil
+	}
+	rn, err := toNumber(right)
+	if err != nil {
+		r.fatalError(fmt.Sprintf("%s expects numbers, %v", op, err), at)
+		return nil
+	}
+	if rn.float() == 0 && op == "/" {
+		r.fatalError("division by zero", at)
+		return nil
+	}
+	if rn.float() == 0 && op == "%" {
+		r.fatalError("modulo by zero", at)
+		return nil
+	}
+	if !l.isFloat && !rn.isFloat {
+		if i, ok := intArithmetic(l.i, rn.i, op); ok {
+			return &ast.IntegerLiteral{Value: i}
+		}
+	}
+	return r.numberValue(number{f: floatArithmetic(l.float(), rn.float(), op), isFloat: true})
+}
+
+// intArithmetic does arithmetic on ints, and reports false if the result
+// isn't a whole number that fits in an int.
+func intArithmetic(a int, b int, op string) (int, bool) {
+	switch op {
+	case "+":
+		c := a + b
+		return c, (c > a) == (b > 0)
+	case "-":
+		c := a - b
+		return c, (c < a) == (b > 0)
+	case "*":
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		c := a * b
+		return c, c/b == a && !(a == -1 && b == math.MinInt) && !(b == -1 && a == math.MinInt)
+	case "/":
+		return a / b, a%b == 0 && !(a == math.MinInt && b == -1)
+	case "%":
+		return a % b, true
+	}
+	return 0, false
+}
+
+func floatArithmetic(a float64, b float64, op string) float64 {
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b
+	case "%":
+		return math.Mod(a, b)
+	}
+	return 0
+}
+
+func (r *Runner) tryCompareNumber(left ast.Expression, right ast.Expression, op string) (ast.Expression, error) {
+	l, l_ok := compareNumber(left)
+	rn, r_ok := compareNumber(right)
+	if !l_ok || !r_ok {
+		return nil, fmt.Errorf("unable to convert to number")
+	}
+	c := compareNumbers(l, rn)
+	switch op {
+	case ">":
+		return &ast.Boolean{Value: c > 0}, nil
+	case "<":
+		return &ast.Boolean{Value: c < 0}, nil
+	case ">=":
+		return &ast.Boolean{Value: c >= 0}, nil
+	case "<=":
+		return &ast.Boolean{Value: c <= 0}, nil
+	case "==":
+		return &ast.Boolean{Value: c == 0}, nil
+	case "!=":
+		return &ast.Boolean{Value: c != 0}, nil
+	}
+	return nil, fmt.Errorf("unknown operator")
+}
+
+// compareNumbers returns -1 if a is less than b, 1 if it is greater, and 0
+// if they are equal.
+func compareNumbers(a number, b number) int {
+	if !a.isFloat && !b.isFloat {
+		return cmp.Compare(a.i, b.i)
+	}
+	return cmp.Compare(a.float(), b.float())
+}
@@ -2,23 +2,25 @@ package runner
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
-	"regexp"
 	"slices"
 	"strconv"
 	"strings"
-	"text/template"
 
 	"github.com/ahalbert/ted/ted/ast"
 	"github.com/edsrzf/mmap-go"
-	"github.com/rwtodd/Go.Sed/sed"
 )
 
 type Runner struct {
 	States                map[string]*State
+	StateNames            []string
 	Variables             map[string]string
+	Collections           map[string]ast.Expression // lists and maps, which Variables can't hold
 	Functions             map[string]*ast.FunctionLiteral
 	StartState            string
 	CurrState             string
@@ -30,6 +32,34 @@ type Runner struct {
 	OutputTape            io.Writer
 	ShouldHalt            bool
 	DidFatalError         bool
+	DidExit               bool
+	ExitCode              int
+	Err                   error
+	Paused                bool
+	Frames                []map[string]string
+	DidReturn             bool
+	ReturnValue           ast.Expression
+	ProgramName           string
+	Program               string
+	InputName             string
+	RecordBase            int // records read from earlier files, so $NR counts across them
+	FileIndex             int // how many input files have been finished
+	Marks                 map[string]Mark
+	Context               context.Context // checked before each record, nil is never done
+	currAction            ast.Action
+	cache                 *compileCache
+	fieldCache            fieldCache
+	jsonCache             jsonCache
+	header                map[string]int // column numbers by name, from the first row of a CSV or TSV file
+	loopDepth             int            // loops the current action is inside, not counting those outside the function it is in
+	loopControl           string         // "break" or "continue" once run, until the loop it applies to sees it
+	pausedCycles          int            // cycles run in a row without the head advancing
+}
+
+// Mark is a position on the tape saved by mark NAME.
+type Mark struct {
+	File   int // FileIndex when the mark was set
+	Record int // index of the record under the head
 }
 
 type State struct {
@@ -40,9 +70,10 @@ type State struct {
 
 func NewRunner(fsa ast.FSA, vars map[string]string) *Runner {
 	r := &Runner{
-		States:    make(map[string]*State),
-		Variables: vars,
-		Functions: make(map[string]*ast.FunctionLiteral),
+		States:      make(map[string]*State),
+		Variables:   vars,
+		Collections: make(map[string]ast.Expression),
+		Functions:   make(map[string]*ast.FunctionLiteral),
 	}
 	r.States["0"] = newState("0")
 	r.Variables["$_"] = ""
@@ -55,6 +86,15 @@ func NewRunner(fsa ast.FSA, vars map[string]string) *Runner {
 	if !ok {
 		r.Variables["$PRINTMODE"] = "print"
 	}
+	_, ok = r.Variables["$RSMODE"]
+	if !ok {
+		r.Variables["$RSMODE"] = "literal"
+	}
+	for name, value := range map[string]string{"$FS": " ", "$OFS": " ", "$FSMODE": "literal", "$FORMAT": "text", "$LOOPLIMIT": strconv.Itoa(DefaultLoopLimit), "$OFMT": ""} {
+		if _, ok := r.Variables[name]; !ok {
+			r.Variables[name] = value
+		}
+	}
 
 	for idx, statement := range fsa.Statements {
 		switch statement.(type) {
@@ -79,7 +119,7 @@ func getNextStateInList(statements []ast.Statement, idx int, currState string) s
 			stmt := statement.(*ast.StateStatement)
 			if stmt.StateName == currState {
 				found = true
-			} else if found && stmt.StateName != "BEGIN" && stmt.StateName != "END" && stmt.StateName != "ALL" {
+			} else if found && !IsSpecialState(stmt.StateName) {
 				return stmt.StateName
 			}
 		}
@@ -88,12 +128,13 @@ func getNextStateInList(statements []ast.Statement, idx int, currState string) s
 }
 
 func (r *Runner) processStateStatement(statement *ast.StateStatement, nextState string) {
-	if r.StartState == "" && statement.StateName != "BEGIN" && statement.StateName != "END" && statement.StateName != "ALL" {
+	if r.StartState == "" && !IsSpecialState(statement.StateName) {
 		r.StartState = statement.StateName
 	}
 	_, ok := r.States[statement.StateName]
 	if !ok {
 		r.States[statement.StateName] = newState(statement.StateName)
+		r.StateNames = append(r.StateNames, statement.StateName)
 	}
 	state, _ := r.States[statement.StateName]
 
@@ -115,6 +156,16 @@ func (r *Runner) processFunctionStatement(statement *ast.FunctionStatement) {
 
 }
 
+// IsSpecialState reports whether name is one of the states ted runs itself
+// rather than by a transition.
+func IsSpecialState(name string) bool {
+	switch name {
+	case "BEGIN", "END", "ALL", "BEGINFILE", "ENDFILE":
+		return true
+	}
+	return false
+}
+
 func newState(stateName string) *State {
 	return &State{StateName: stateName,
 		Actions:   []ast.Action{},
@@ -126,70 +177,208 @@ func (s *State) addRule(action ast.Action) {
 	s.Actions = append(s.Actions, action)
 }
 
-func (r *Runner) RunFSAFromString(input string, out io.Writer) {
+func (r *Runner) RunFSAFromString(input string, out io.Writer) error {
+	r.InputName = ""
 	r.Tape = NewStringTape(input)
 	r.OutputTape = out
-	r.RunFSA()
+	return r.RunFSA()
 }
 
-func (r *Runner) RunFSAFromFile(in *os.File, out io.Writer) {
-	mmap, err := mmap.Map(in, mmap.RDONLY, 0)
+func (r *Runner) RunFSAFromReader(in io.Reader, history int, out io.Writer) error {
+	r.InputName = ""
+	if named, ok := in.(interface{ Name() string }); ok {
+		r.InputName = named.Name()
+	}
+	tape := NewStreamTape(in, history)
+	r.Tape = tape
+	r.OutputTape = out
+	err := r.RunFSA()
+	if tape.err != nil && (err == nil || r.DidExit) {
+		err = &IOError{Name: r.InputName, Err: tape.err}
+	}
+	return err
+}
+
+func (r *Runner) RunFSAFromFile(in *os.File, out io.Writer) error {
+	r.OutputTape = out
+	unmap, err := r.mapFile(in)
 	if err != nil {
-		panic("mmap error")
+		return err
 	}
-	defer mmap.Unmap()
-	r.Tape = NewReversibleScanner(mmap)
+	defer unmap()
+	return r.RunFSA()
+}
+
+// RunFSAFromFiles runs one machine over each named file in turn. BEGIN and
+// END run once, and BEGINFILE and ENDFILE run around each file. If reset is
+// true the machine goes back to its start state at the start of each file,
+// otherwise it carries on from where the previous file left it, as if the
+// files were concatenated. Files that can't be read are skipped, and their
+// errors are joined with any error from the run.
+func (r *Runner) RunFSAFromFiles(names []string, reset bool, out io.Writer) error {
 	r.OutputTape = out
-	r.RunFSA()
+	r.begin()
+	var errs []error
+	for _, name := range names {
+		if r.ShouldHalt {
+			break
+		}
+		in, err := os.Open(name)
+		if err != nil {
+			errs = append(errs, &IOError{Err: err})
+			continue
+		}
+		unmap, err := r.mapFile(in)
+		if err != nil {
+			in.Close()
+			errs = append(errs, err)
+			continue
+		}
+		if reset {
+			r.resetMachine()
+		}
+		r.runFile()
+		unmap()
+		in.Close()
+	}
+	return errors.Join(append(errs, r.end())...)
+}
+
+// mapFile points the tape at a memory mapped copy of in.
+func (r *Runner) mapFile(in *os.File) (func(), error) {
+	info, err := in.Stat()
+	if err != nil {
+		return nil, &IOError{Name: in.Name(), Err: err}
+	}
+	r.InputName = in.Name()
+	//mmap refuses empty files, but an empty file is still valid input.
+	if info.Size() == 0 {
+		r.Tape = NewReversibleScanner(nil)
+		return func() {}, nil
+	}
+	m, err := mmap.Map(in, mmap.RDONLY, 0)
+	if err != nil {
+		return nil, &IOError{Name: in.Name(), Err: err}
+	}
+	r.Tape = NewReversibleScanner(m)
+	return func() { m.Unmap() }, nil
 }
 
-func (r *Runner) RunFSA() {
+// RunFSA runs the program over r.Tape. It returns a *RuntimeError if the
+// program fails, an *IOError if writing output fails, or an *ExitError if the
+// program runs exit with a non-zero status.
+func (r *Runner) RunFSA() error {
+	r.begin()
+	r.runFile()
+	return r.end()
+}
+
+// begin resets the runner and runs the BEGIN state.
+func (r *Runner) begin() {
+	r.ShouldHalt = false
 	r.DidFatalError = false
+	r.DidExit = false
+	r.ExitCode = 0
+	r.Err = nil
+	r.RecordBase = 0
+	r.FileIndex = 0
+	r.Marks = make(map[string]Mark)
+	for _, name := range []string{"$NR", "$FNR", "$OFFSET", "$LEN"} {
+		r.clearAndSetVariable(name, "0")
+	}
+	r.clearAndSetVariable("$FILENAME", "")
+	r.clearAndSetVariable("$RT", "")
+	r.clearAndSetVariable("$HEADER", "")
 
 	if r.StartState == "" {
 		r.StartState = "0"
 	}
 	r.CurrState = r.StartState
+	r.Paused = false
 	//Run BEGIN State - may have transitions so we should set CurrState before running any.
-	state, ok := r.States["BEGIN"]
-	if ok {
-		for _, action := range state.Actions {
-			if r.DidTransition {
-				break
-			}
-			r.doAction(action)
-		}
-	}
+	r.runSpecialState("BEGIN")
+
+	r.resetCaptureMode()
+}
 
-	r.Tape.Split(r.getVariable("$RS"))
+// resetMachine puts the machine back in its start state, for a new file.
+func (r *Runner) resetMachine() {
+	r.CurrState = r.StartState
+	r.Paused = false
+	r.resetCaptureMode()
+}
 
+func (r *Runner) resetCaptureMode() {
 	if r.getVariable("$PRINTMODE") == "noprint" {
 		r.CaptureMode = "capture"
 		r.CaptureVar = "$NULL"
 	} else {
 		r.CaptureMode = "nocapture"
 	}
+}
+
+// runSpecialState runs the actions of a state such as BEGIN, stopping at the
+// first transition.
+func (r *Runner) runSpecialState(name string) {
+	state, ok := r.States[name]
+	if !ok {
+		return
+	}
+	r.DidTransition = false
+	for _, action := range state.Actions {
+		if r.DidTransition || r.ShouldHalt {
+			break
+		}
+		r.doAction(action)
+	}
+}
+
+// runFile runs the machine over every record of r.Tape, with BEGINFILE
+// before the first record and ENDFILE after the last.
+func (r *Runner) runFile() {
+	if r.ShouldHalt {
+		return
+	}
+	if !r.splitTape(r.Tape) {
+		return
+	}
+	format := r.getVariable("$FORMAT")
+	r.Paused = false
+	r.clearAndSetVariable("$FILENAME", r.InputName)
+	r.clearAndSetVariable("$FNR", "0")
+	r.clearAndSetVariable("$NR", strconv.Itoa(r.RecordBase))
+	if format == "csv" || format == "tsv" {
+		r.readHeader()
+	}
+	r.runSpecialState("BEGINFILE")
 
 	//Run FSA
 	for !r.ShouldHalt {
-		if !r.Tape.Next() {
-			r.ShouldHalt = true
+		if r.contextDone() {
 			break
 		}
-		line := r.Tape.Text()
-		r.clearAndSetVariable("$@", line)
+		//While paused the head stays on the current record, so $@ and $_ carry over.
+		if !r.Paused {
+			r.pausedCycles = 0
+			if !r.Tape.Next() {
+				r.ShouldHalt = true
+				break
+			}
+			r.readRecord()
 
-		if !(r.CaptureVar == "$_" && r.CaptureMode == "capture") {
-			r.clearAndSetVariable("$_", r.getVariable("$@"))
-			r.DidResetUnderscoreVar = true
-		} else {
-			r.DidResetUnderscoreVar = false
+			if !(r.CaptureVar == "$_" && r.CaptureMode == "capture") {
+				r.clearAndSetVariable("$_", r.getVariable("$@"))
+				r.DidResetUnderscoreVar = true
+			} else {
+				r.DidResetUnderscoreVar = false
+			}
 		}
 
 		r.DidTransition = false
 		state, ok := r.States[r.CurrState]
 		if !ok {
-			panic("missing state:" + r.CurrState)
+			r.fatalError("missing state: "+r.CurrState, nil)
+			break
 		}
 		for _, action := range state.Actions {
 			if r.DidTransition || r.ShouldHalt {
@@ -209,36 +398,144 @@ func (r *Runner) RunFSA() {
 			}
 		}
 
-		if r.CaptureMode == "capture" {
-			r.appendToVariable(r.CaptureVar, r.getVariable("$@")+r.getVariable("$RS"))
+		if r.DidFatalError {
+			break
+		} else if r.Paused {
+			r.checkPausedCycles()
+			continue
+		} else if r.CaptureMode == "capture" {
+			r.appendToVariable(r.CaptureVar, r.getVariable("$@")+r.recordSeperator())
 		} else if r.CaptureMode == "temp" {
 			r.CaptureMode = "nocapture"
 		} else if r.getVariable("$PRINTMODE") == "print" {
-			_, err := io.WriteString(r.OutputTape, r.getVariable("$_")+r.getVariable("$RS"))
+			_, err := io.WriteString(r.OutputTape, r.getVariable("$_")+r.recordSeperator())
 			if err != nil {
-				r.fatalError(err.Error(), nil)
+				r.ioError(err)
 			}
 			r.clearAndSetVariable("$_", "")
 		} else {
 			r.clearAndSetVariable("$_", "")
 		}
 	}
+	if r.DidExit || r.DidFatalError {
+		return
+	}
 
-	//Run END state
+	//The head has run off the end, so it is one past the last record.
+	r.RecordBase += r.Tape.Offset()
+	r.ShouldHalt = false
+	r.runSpecialState("ENDFILE")
+	r.FileIndex++
+}
+
+// splitTape splits tape into records the way $FORMAT, $RS and $RSMODE say
+// to, returning false if they are invalid.
+func (r *Runner) splitTape(tape Tape) bool {
+	format := r.getVariable("$FORMAT")
+	switch {
+	case format == "csv" || format == "tsv":
+		tape.SplitFunc(CSVSeperator)
+	case format == "jsonl":
+		tape.Split("\n")
+	case format != "text":
+		r.fatalError("unknown $FORMAT "+strconv.Quote(format), nil)
+		return false
+	case r.getVariable("$RSMODE") == "regex":
+		re, err := r.compileRegex(r.getVariable("$RS"))
+		if err != nil {
+			r.fatalError("$RS is not a valid regex: "+err.Error(), nil)
+			return false
+		}
+		tape.SplitFunc(RegexSeperator(re))
+	default:
+		tape.Split(r.getVariable("$RS"))
+	}
+	return true
+}
+
+// contextDone stops the run if r.Context is done.
+func (r *Runner) contextDone() bool {
+	if r.Context == nil || r.Context.Err() == nil {
+		return false
+	}
+	r.ShouldHalt = true
+	r.DidFatalError = true
+	if r.Err == nil {
+		r.Err = r.Context.Err()
+	}
+	return true
+}
+
+// readRecord copies the record under the head into $@, the seperator after
+// it into $RT, and where it is on the tape into $FNR, $NR, $OFFSET and $LEN.
+func (r *Runner) readRecord() {
+	line := r.Tape.Text()
+	r.fieldCache.valid = false
+	r.jsonCache.valid = false
+	r.clearAndSetVariable("$@", line)
+	r.clearAndSetVariable("$RT", r.Tape.Terminator())
+	fnr := r.Tape.Offset() + 1
+	r.clearAndSetVariable("$FNR", strconv.Itoa(fnr))
+	r.clearAndSetVariable("$NR", strconv.Itoa(r.RecordBase+fnr))
+	r.clearAndSetVariable("$OFFSET", strconv.Itoa(r.Tape.ByteOffset()))
+	r.clearAndSetVariable("$LEN", strconv.Itoa(len(line)))
+}
+
+// recordSeperator is what is written after each record. With a regex $RS,
+// or a $FORMAT other than text, it is $RT, so the seperators in the input are
+// kept as they were.
+func (r *Runner) recordSeperator() string {
+	if r.getVariable("$RSMODE") == "regex" || r.getVariable("$FORMAT") != "text" {
+		return r.getVariable("$RT")
+	}
+	return r.getVariable("$RS")
+}
+
+// end runs the END state and returns the error that stopped the run, if any.
+func (r *Runner) end() error {
+	//Run END state. exit outside END still runs it, exit inside END stops it.
 	r.CurrState = "END"
-	state, ok = r.States[r.CurrState]
+	r.DidExit = false
+	state, ok := r.States[r.CurrState]
 	if ok && !r.DidFatalError {
 		for _, action := range state.Actions {
-			if r.DidFatalError || r.DidTransition {
+			if r.DidFatalError || r.DidTransition || r.DidExit {
 				break
 			}
 			r.doAction(action)
 		}
 	}
+
+	if r.Err == nil && r.ExitCode != 0 {
+		return &ExitError{Code: r.ExitCode}
+	}
+	return r.Err
+}
+
+func (r *Runner) currentFrame() map[string]string {
+	if len(r.Frames) == 0 {
+		return nil
+	}
+	return r.Frames[len(r.Frames)-1]
+}
+
+// scopeFor returns the map a variable should be read from or written to.
+// Function parameters live in the innermost frame and shadow globals.
+func (r *Runner) scopeFor(key string) map[string]string {
+	frame := r.currentFrame()
+	if frame != nil {
+		if _, ok := frame[key]; ok {
+			return frame
+		}
+	}
+	return r.Variables
 }
 
 func (r *Runner) getVariable(key string) string {
-	val, ok := r.Variables[key]
+	if val, ok := r.lookupField(key); ok {
+		return val
+	}
+	val, ok := r.scopeFor(key)[key]
 	if !ok {
 		r.fatalError("Attempted to reference non-existent variable:"+key, nil)
 		return ""
@@ -250,17 +547,30 @@ func (r *Runner) appendToVariable(key string, apendee string) string {
 	if key == "$NULL" {
 		return ""
 	}
-	val, ok := r.Variables[key]
+	scope := r.scopeFor(key)
+	val, ok := scope[key]
 	if !ok {
 		val = ""
 	}
 	val = val + apendee
-	r.Variables[key] = val
+	scope[key] = val
 	return val
 }
 
 func (r *Runner) clearAndSetVariable(key string, toset string) {
+	if r.assignField(key, toset) {
+		return
+	}
+	if frame := r.currentFrame(); frame != nil {
+		if _, ok := frame[key]; ok {
+			frame[key] = toset
+			return
+		}
+	}
 	r.Variables[key] = toset
+	if len(r.Collections) > 0 {
+		delete(r.Collections, key)
+	}
 }
 
 func (r *Runner) doTransition(newState string) {
@@ -269,13 +579,46 @@ func (r *Runner) doTransition(newState string) {
 }
 
 func (r *Runner) applyVariablesToString(input string) string {
+	if !strings.Contains(input, "{{") {
+		return input
+	}
+	t, err := r.compileTemplate(input)
+	if err != nil {
+		r.fatalError("template error: "+err.Error(), nil)
+		return input
+	}
 	var output bytes.Buffer
-	t := template.Must(template.New("").Parse(input))
-	t.Execute(&output, r.Variables)
+	vars := r.templateVariables()
+	if strings.Contains(input, "$F") || strings.Contains(input, "$NF") || strings.Contains(input, "$.") {
+		vars = r.withFields(vars)
+	}
+	t.Execute(&output, vars)
 	return output.String()
 }
 
+func (r *Runner) templateVariables() map[string]string {
+	frame := r.currentFrame()
+	if len(frame) == 0 {
+		return r.Variables
+	}
+	vars := make(map[string]string, len(r.Variables)+len(frame))
+	for k, v := range r.Variables {
+		vars[k] = v
+	}
+	for k, v := range frame {
+		vars[k] = v
+	}
+	return vars
+}
+
 func (r *Runner) doAction(action ast.Action) {
+	prev := r.currAction
+	r.currAction = action
+	r.dispatchAction(action)
+	r.currAction = prev
+}
+
+func (r *Runner) dispatchAction(action ast.Action) {
 	switch action.(type) {
 	case *ast.ActionBlock:
 		r.doActionBlock(action.(*ast.ActionBlock))
@@ -303,10 +646,26 @@ func (r *Runner) doAction(action ast.Action) {
 		r.doAssignAction(action.(*ast.AssignAction))
 	case *ast.MoveHeadAction:
 		r.doMoveHeadAction(action.(*ast.MoveHeadAction))
+	case *ast.MarkAction:
+		r.doMarkAction(action.(*ast.MarkAction))
+	case *ast.EditTapeAction:
+		r.doEditTapeAction(action.(*ast.EditTapeAction))
+	case *ast.DeleteElementAction:
+		r.doDeleteElementAction(action.(*ast.DeleteElementAction))
 	case *ast.IfAction:
 		r.doIfAction(action.(*ast.IfAction))
+	case *ast.ForAction:
+		r.doForAction(action.(*ast.ForAction))
+	case *ast.WhileAction:
+		r.doWhileAction(action.(*ast.WhileAction))
+	case *ast.LoopControlAction:
+		r.doLoopControlAction(action.(*ast.LoopControlAction))
 	case *ast.ExpressionAction:
 		r.doExpressionAction(action.(*ast.ExpressionAction))
+	case *ast.ReturnAction:
+		r.doReturnAction(action.(*ast.ReturnAction))
+	case *ast.ExitAction:
+		r.doExitAction(action.(*ast.ExitAction))
 	case nil:
 		r.doNoOp()
 	default:
@@ -316,7 +675,7 @@ func (r *Runner) doAction(action ast.Action) {
 
 func (r *Runner) doActionBlock(block *ast.ActionBlock) {
 	for _, action := range block.Actions {
-		if r.ShouldHalt && r.CurrState != "END" {
+		if (r.ShouldHalt && r.CurrState != "END") || r.DidReturn || r.DidExit || r.DidFatalError || r.loopControl != "" {
 			break
 		}
 		r.doAction(action)
@@ -325,7 +684,7 @@ func (r *Runner) doActionBlock(block *ast.ActionBlock) {
 
 func (r *Runner) doRegexAction(action *ast.RegexAction) {
 	rule := r.applyVariablesToString(action.Rule)
-	re, err := regexp.Compile(rule)
+	re, err := r.compileRegex(rule)
 	if err != nil {
 		r.fatalError("regexp error, supplied: "+action.Rule+"\n formatted as: "+rule, action)
 		return
@@ -333,17 +692,14 @@ func (r *Runner) doRegexAction(action *ast.RegexAction) {
 
 	matches := re.FindStringSubmatch(r.getVariable("$@"))
 	if matches != nil {
-		for idx, match := range matches {
-			stridx := "$" + strconv.Itoa(idx)
-			r.clearAndSetVariable(stridx, match)
-		}
+		r.setCaptureGroups(matches)
 		r.doAction(action.Action)
 	}
 }
 
 func (r *Runner) doSedAction(action *ast.DoSedAction) {
 	command := r.applyVariablesToString(action.Command)
-	engine, err := sed.New(strings.NewReader(command))
+	engine, err := r.compileSed(command)
 	if err != nil {
 		r.fatalError("error building sed engine with command: '"+action.Command+"'\n formatted as: '"+command+"'", action)
 		return
@@ -359,13 +715,13 @@ func (r *Runner) doSedAction(action *ast.DoSedAction) {
 	if action.Variable == "$_" && r.CaptureMode != "capture" {
 		r.clearAndSetVariable(action.Variable, result)
 	} else {
-		r.clearAndSetVariable(action.Variable, result+r.getVariable("$RS"))
+		r.clearAndSetVariable(action.Variable, result+r.recordSeperator())
 	}
 }
 
 func (r *Runner) doUntilSedAction(action *ast.DoUntilSedAction) {
 	command := r.applyVariablesToString(action.Command)
-	engine, err := sed.New(strings.NewReader(command))
+	engine, err := r.compileSed(command)
 	if err != nil {
 		r.fatalError("error building sed engine with command: '"+action.Command+"'\n formatted as: '"+command+"'", action)
 		return
@@ -380,7 +736,7 @@ func (r *Runner) doUntilSedAction(action *ast.DoUntilSedAction) {
 	if action.Variable == "$_" && r.CaptureMode != "capture" {
 		r.clearAndSetVariable(action.Variable, result)
 	} else {
-		r.clearAndSetVariable(action.Variable, result+r.getVariable("$RS"))
+		r.clearAndSetVariable(action.Variable, result+r.recordSeperator())
 	}
 	if orig != result {
 		r.doAction(action.Action)
@@ -407,6 +763,9 @@ func (r *Runner) doResetAction(action *ast.ResetAction) {
 
 func (r *Runner) doPrintAction(action *ast.PrintAction) {
 	val := r.evaluateExpression(action.Expression)
+	if val == nil || r.DidFatalError {
+		return
+	}
 	var err error
 	switch val.(type) {
 	case *ast.StringLiteral:
@@ -419,17 +778,22 @@ func (r *Runner) doPrintAction(action *ast.PrintAction) {
 		} else {
 			_, err = io.WriteString(r.OutputTape, "false")
 		}
+	case *ast.FloatLiteral, *ast.ListLiteral, *ast.Map:
+		_, err = io.WriteString(r.OutputTape, val.String())
 	default:
 		r.fatalError(fmt.Sprintf("cannot print type %v", val), action)
 		return
 	}
 	if err != nil {
-		r.fatalError(err.Error(), action)
+		r.ioError(err)
 	}
 }
 
 func (r *Runner) doPrintLnAction(action *ast.PrintLnAction) {
 	val := r.evaluateExpression(action.Expression)
+	if val == nil || r.DidFatalError {
+		return
+	}
 	var err error
 	switch val.(type) {
 	case *ast.StringLiteral:
@@ -442,11 +806,13 @@ func (r *Runner) doPrintLnAction(action *ast.PrintLnAction) {
 		} else {
 			_, err = io.WriteString(r.OutputTape, "false"+"\n")
 		}
+	case *ast.FloatLiteral, *ast.ListLiteral, *ast.Map:
+		_, err = io.WriteString(r.OutputTape, val.String()+"\n")
 	default:
 		r.fatalError(fmt.Sprintf("cannot print type %v", val), action)
 	}
 	if err != nil {
-		r.fatalError(err.Error(), action)
+		r.ioError(err)
 	}
 }
 
@@ -481,8 +847,18 @@ func (r *Runner) doClearAction(action *ast.ClearAction) {
 }
 
 func (r *Runner) doAssignAction(action *ast.AssignAction) {
-	val := r.evaluateExpression(action.Expression).String() //TODO: check if this is safe
-	r.Variables[action.Target] = val
+	val := r.evaluateExpression(action.Expression)
+	if val == nil {
+		r.fatalError("expression did not produce a value", action)
+		return
+	}
+	if action.Index != nil {
+		r.doAssignElement(action, val)
+	} else if isCollection(val) {
+		r.setCollection(action.Target, copyCollection(val))
+	} else {
+		r.clearAndSetVariable(action.Target, val.String())
+	}
 }
 
 func (r *Runner) evaluateExpression(expression ast.Expression) ast.Expression {
@@ -491,16 +867,39 @@ func (r *Runner) evaluateExpression(expression ast.Expression) ast.Expression {
 		return expression
 	case *ast.IntegerLiteral:
 		return expression
+	case *ast.FloatLiteral:
+		return expression
 	case *ast.StringLiteral:
 		return expression
+	case *ast.RegexLiteral:
+		return &ast.StringLiteral{Value: r.applyVariablesToString(expression.(*ast.RegexLiteral).Value)}
 	case *ast.Identifier:
-		return &ast.StringLiteral{Value: r.getVariable(expression.(*ast.Identifier).Value)}
+		ident := expression.(*ast.Identifier)
+		if val, ok := r.lookupField(ident.Value); ok {
+			return &ast.StringLiteral{Value: val}
+		}
+		if c, ok := r.lookupCollection(ident.Value); ok {
+			return c
+		}
+		val, ok := r.scopeFor(ident.Value)[ident.Value]
+		if !ok {
+			r.fatalError("Attempted to reference non-existent variable:"+ident.Value, &ast.ExpressionAction{Expression: ident})
+		}
+		return &ast.StringLiteral{Value: val}
 	case *ast.PrefixExpression:
 		return r.evaluatePrefixExpression(expression.(*ast.PrefixExpression))
 	case *ast.InfixExpression:
 		return r.evaluateInfixExpression(expression.(*ast.InfixExpression))
 	case *ast.CallExpression:
 		return r.evaluateCallExpression(expression.(*ast.CallExpression))
+	case *ast.ListLiteral:
+		return r.evaluateListLiteral(expression.(*ast.ListLiteral))
+	case *ast.MapLiteral:
+		return r.evaluateMapLiteral(expression.(*ast.MapLiteral))
+	case *ast.Map:
+		return expression
+	case *ast.IndexExpression:
+		return r.evaluateIndexExpression(expression.(*ast.IndexExpression))
 	}
 	return nil
 }
@@ -509,31 +908,53 @@ func (r *Runner) evaluatePrefixExpression(expression *ast.PrefixExpression) ast.
 	right := r.evaluateExpression(expression.Right)
 	switch expression.Operator {
 	case "!":
-		switch right.(type) {
-		case *ast.Boolean:
-			return &ast.Boolean{Value: !right.(*ast.Boolean).Value}
-		default:
-			r.fatalError("! operation expects boolean.", &ast.ExpressionAction{Expression: right})
+		if right == nil {
+			return nil
 		}
+		return &ast.Boolean{Value: !r.truthy(right)}
 	case "-":
-		switch right.(type) {
-		case *ast.IntegerLiteral:
-			return &ast.IntegerLiteral{Value: -1 * right.(*ast.IntegerLiteral).Value}
-		default:
-			r.fatalError("- operation expects integer.", &ast.ExpressionAction{Expression: right})
+		if right == nil {
+			return nil
+		}
+		n, err := toNumber(right)
+		if err != nil {
+			r.fatalError(fmt.Sprintf("- expects a number, %v", err), &ast.ExpressionAction{Expression: expression})
+			return nil
+		}
+		if n.isFloat {
+			return r.numberValue(number{f: -n.f, isFloat: true})
 		}
+		return r.numberValue(number{i: -n.i})
 	}
 	return nil
 }
 
 func (r *Runner) evaluateInfixExpression(expression *ast.InfixExpression) ast.Expression {
+	switch expression.Operator {
+	case "in":
+		return r.evaluateIn(expression)
+	case "&&", "||":
+		return r.evaluateLogical(expression)
+	case "~", "!~":
+		return r.evaluateMatch(expression)
+	}
 	left := r.evaluateExpression(expression.Left)
 	right := r.evaluateExpression(expression.Right)
-	if slices.Contains([]string{"+", "-", "*", "/"}, expression.Operator) {
-		return r.evaluateArithmetic(left, right, expression.Operator)
+	if left == nil || right == nil {
+		return nil
+	}
+	if slices.Contains([]string{"+", "-", "*", "/", "%"}, expression.Operator) {
+		return r.evaluateArithmetic(left, right, expression)
 
-	} else if slices.Contains([]string{">", "<", "!=", "=="}, expression.Operator) {
-		result, err := r.tryCompareInt(left, right, expression.Operator)
+	} else if expression.Operator == ".." {
+		return &ast.StringLiteral{Value: left.String() + right.String()}
+
+	} else if slices.Contains([]string{">", "<", ">=", "<=", "!=", "=="}, expression.Operator) {
+		if isCollection(left) || isCollection(right) {
+			r.fatalError("can't compare a list or map with "+expression.Operator, &ast.ExpressionAction{Expression: expression})
+			return nil
+		}
+		result, err := r.tryCompareNumber(left, right, expression.Operator)
 		if err == nil {
 			return result
 		}
@@ -550,24 +971,9 @@ func (r *Runner) evaluateInfixExpression(expression *ast.InfixExpression) ast.Ex
 	return nil
 }
 
-func (r *Runner) evaluateArithmetic(left ast.Expression, right ast.Expression, op string) ast.Expression {
-	l_int, _ := r.convertToInt(left)
-	r_int, r_err := r.convertToInt(right)
-	switch op {
-	case "+":
-		return &ast.IntegerLiteral{Value: l_int + r_int}
-	case "-":
-		return &ast.IntegerLiteral{Value: l_int - r_int}
-	case "*":
-		return &ast.IntegerLiteral{Value: l_int * r_int}
-	case "/":
-		if r_err != nil {
-			return &ast.IntegerLiteral{Value: 0}
-		}
-		return &ast.IntegerLiteral{Value: l_int / r_int}
-	}
-	return nil
-}
+// errIntRange is returned by convertToInt for a whole number too big for an
+// int.
+var errIntRange = errors.New("out of range for an int")
 
 func (r *Runner) convertToInt(expression ast.Expression) (int, error) {
 	switch expression.(type) {
@@ -579,30 +985,21 @@ func (r *Runner) convertToInt(expression ast.Expression) (int, error) {
 		return val, nil
 	case *ast.IntegerLiteral:
 		return expression.(*ast.IntegerLiteral).Value, nil
+	case *ast.FloatLiteral:
+		f := expression.(*ast.FloatLiteral).Value
+		if f != math.Trunc(f) {
+			return 0, fmt.Errorf("type error expected a whole number")
+		}
+		i, ok := number{f: f, isFloat: true}.truncate()
+		if !ok {
+			return 0, fmt.Errorf("%s is %w", expression.String(), errIntRange)
+		}
+		return i, nil
 	default:
 		return 0, fmt.Errorf("type error expected int or string-like int")
 	}
 }
 
-func (r *Runner) tryCompareInt(left ast.Expression, right ast.Expression, op string) (ast.Expression, error) {
-	l_int, l_err := r.convertToInt(left)
-	r_int, r_err := r.convertToInt(right)
-	if l_err != nil || r_err != nil {
-		return nil, fmt.Errorf("unable to convert to int")
-	}
-	switch op {
-	case ">":
-		return &ast.Boolean{Value: l_int > r_int}, nil
-	case "<":
-		return &ast.Boolean{Value: l_int < r_int}, nil
-	case "==":
-		return &ast.Boolean{Value: l_int == r_int}, nil
-	case "!=":
-		return &ast.Boolean{Value: l_int != r_int}, nil
-	}
-	return nil, fmt.Errorf("unknown operator")
-}
-
 func (r *Runner) convertToBool(expression ast.Expression) (bool, error) {
 	switch expression.(type) {
 	case *ast.StringLiteral:
@@ -632,6 +1029,8 @@ func (r *Runner) tryCompareBool(left ast.Expression, right ast.Expression, op st
 		return &ast.Boolean{Value: false}, fmt.Errorf("> not compatible with bool compare")
 	case "<":
 		return &ast.Boolean{Value: false}, fmt.Errorf("< not compatible with bool compare")
+	case ">=", "<=":
+		return &ast.Boolean{Value: false}, fmt.Errorf("%s not compatible with bool compare", op)
 	case "==":
 		return &ast.Boolean{Value: lbool == rbool}, nil
 	case "!=":
@@ -641,13 +1040,17 @@ func (r *Runner) tryCompareBool(left ast.Expression, right ast.Expression, op st
 }
 
 func (r *Runner) tryCompareString(left ast.Expression, right ast.Expression, op string) (ast.Expression, error) {
-	leftStr := left.(*ast.StringLiteral).Value
-	rightStr := right.(*ast.StringLiteral).Value
+	leftStr := left.String()
+	rightStr := right.String()
 	switch op {
 	case ">":
 		return &ast.Boolean{Value: leftStr > rightStr}, nil
 	case "<":
 		return &ast.Boolean{Value: leftStr < rightStr}, nil
+	case ">=":
+		return &ast.Boolean{Value: leftStr >= rightStr}, nil
+	case "<=":
+		return &ast.Boolean{Value: leftStr <= rightStr}, nil
 	case "==":
 		return &ast.Boolean{Value: leftStr == rightStr}, nil
 	case "!=":
@@ -656,44 +1059,196 @@ func (r *Runner) tryCompareString(left ast.Expression, right ast.Expression, op
 	return nil, fmt.Errorf("unknown operator")
 }
 
+// truthy is how a value reads with !, && and ||: false, 0, the empty string
+// and empty lists and maps are false, and anything else is true.
+func (r *Runner) truthy(value ast.Expression) bool {
+	switch v := value.(type) {
+	case *ast.Boolean:
+		return v.Value
+	case *ast.IntegerLiteral:
+		return v.Value != 0
+	case *ast.FloatLiteral:
+		return v.Value != 0
+	case *ast.StringLiteral:
+		return v.Value != "" && v.Value != "0" && v.Value != "false"
+	case *ast.ListLiteral:
+		return len(v.Elements) > 0
+	case *ast.Map:
+		return len(v.Keys) > 0
+	}
+	return false
+}
+
+// evaluateLogical evaluates && and ||, only evaluating the right side if
+// the left doesn't decide the result.
+func (r *Runner) evaluateLogical(expression *ast.InfixExpression) ast.Expression {
+	left := r.evaluateExpression(expression.Left)
+	if left == nil {
+		return nil
+	}
+	if r.truthy(left) != (expression.Operator == "&&") {
+		return &ast.Boolean{Value: expression.Operator == "||"}
+	}
+	right := r.evaluateExpression(expression.Right)
+	if right == nil {
+		return nil
+	}
+	return &ast.Boolean{Value: r.truthy(right)}
+}
+
+// evaluateMatch reports whether the left side matches the regex on the
+// right, or doesn't for !~. Like a /regex/ action, a match sets $0..$N.
+func (r *Runner) evaluateMatch(expression *ast.InfixExpression) ast.Expression {
+	left := r.evaluateExpression(expression.Left)
+	if left == nil {
+		return nil
+	}
+	right := r.evaluateExpression(expression.Right)
+	if right == nil {
+		return nil
+	}
+	rule := right.String()
+	re, err := r.compileRegex(rule)
+	if err != nil {
+		r.fatalError("regexp error, supplied: "+expression.Right.String()+"\n formatted as: "+rule, &ast.ExpressionAction{Expression: expression})
+		return nil
+	}
+	matches := re.FindStringSubmatch(left.String())
+	if matches != nil && expression.Operator == "~" {
+		r.setCaptureGroups(matches)
+	}
+	return &ast.Boolean{Value: (matches != nil) == (expression.Operator == "~")}
+}
+
+// setCaptureGroups sets $0 to what a regex matched, and $1..$N to its
+// capture groups.
+func (r *Runner) setCaptureGroups(matches []string) {
+	for idx, match := range matches {
+		r.clearAndSetVariable("$"+strconv.Itoa(idx), match)
+	}
+}
+
 func (r *Runner) evaluateCallExpression(expression *ast.CallExpression) ast.Expression {
 	switch expression.Function.(type) {
 	case *ast.Identifier:
-		r.lookupAndEvaluateFunction(expression)
+		return r.lookupAndEvaluateFunction(expression)
 	case *ast.FunctionLiteral:
-		r.evaluateFunctionLiteral(expression)
+		return r.evaluateFunctionLiteral(expression)
 	}
-	return expression
+	return nil
 }
 
-func (r *Runner) lookupAndEvaluateFunction(expression *ast.CallExpression) {
+func (r *Runner) lookupAndEvaluateFunction(expression *ast.CallExpression) ast.Expression {
 	fnName := expression.Function.(*ast.Identifier).Value
 	fn, ok := r.Functions[fnName]
+	if builtin, isBuiltin := builtins[fnName]; !ok && isBuiltin {
+		return builtin(r, expression)
+	}
 	if !ok {
 		r.fatalError("function "+fnName+" not found!", &ast.ExpressionAction{Expression: expression})
-		return
+		return nil
 	}
-	r.doAction(fn.Body)
+	return r.callFunction(fn, expression)
 }
 
-func (r *Runner) evaluateFunctionLiteral(expression *ast.CallExpression) {
+func (r *Runner) evaluateFunctionLiteral(expression *ast.CallExpression) ast.Expression {
 	function := expression.Function.(*ast.FunctionLiteral)
-	r.doAction(function.Body)
+	return r.callFunction(function, expression)
+}
+
+// callFunction evaluates the arguments in the caller's scope, binds them to
+// the function's parameters in a new frame and runs the body. A call
+// without an explicit return evaluates to the empty string.
+func (r *Runner) callFunction(fn *ast.FunctionLiteral, expression *ast.CallExpression) ast.Expression {
+	if len(expression.Arguments) != len(fn.Parameters) {
+		r.fatalError(fmt.Sprintf("function expects %d arguments, got %d", len(fn.Parameters), len(expression.Arguments)), &ast.ExpressionAction{Expression: expression})
+		return nil
+	}
+	frame := make(map[string]string, len(fn.Parameters))
+	for idx, param := range fn.Parameters {
+		val := r.evaluateExpression(expression.Arguments[idx])
+		if val == nil {
+			r.fatalError("argument "+param.Value+" did not produce a value", &ast.ExpressionAction{Expression: expression})
+			return nil
+		}
+		if isCollection(val) {
+			r.fatalError("argument "+param.Value+" is a list or map, but parameters can only be strings; use a global instead", &ast.ExpressionAction{Expression: expression})
+			return nil
+		}
+		frame[param.Value] = val.String()
+	}
+
+	r.Frames = append(r.Frames, frame)
+	r.ReturnValue = nil
+	loopDepth := r.loopDepth
+	r.loopDepth = 0
+	r.doAction(fn.Body)
+	r.loopDepth = loopDepth
+	r.Frames = r.Frames[:len(r.Frames)-1]
+
+	result := r.ReturnValue
+	r.ReturnValue = nil
+	r.DidReturn = false
+	if result == nil {
+		return &ast.StringLiteral{Value: ""}
+	}
+	return result
+}
+
+func (r *Runner) doReturnAction(action *ast.ReturnAction) {
+	if len(r.Frames) == 0 {
+		r.fatalError("return outside of function", action)
+		return
+	}
+	if action.Expression != nil {
+		r.ReturnValue = r.evaluateExpression(action.Expression)
+	}
+	r.DidReturn = true
+}
+
+func (r *Runner) doExitAction(action *ast.ExitAction) {
+	if action.Expression != nil {
+		code, err := r.convertToInt(r.evaluateExpression(action.Expression))
+		if errors.Is(err, errIntRange) {
+			r.fatalError("exit status "+err.Error(), action)
+			return
+		}
+		if err != nil {
+			r.fatalError("exit expects an integer status", action)
+			return
+		}
+		r.ExitCode = code
+	}
+	r.ShouldHalt = true
+	r.DidExit = true
 }
 
 func (r *Runner) doMoveHeadAction(action *ast.MoveHeadAction) {
-	if action.Command == "fastforward" {
-		r.doFastForward(action.Regex)
+	if action.Mark != "" {
+		r.doRewindToMark(action)
+	} else if action.Command == "fastforward" && action.Count != nil {
+		r.doMoveBy(action, 1)
+	} else if action.Command == "fastforward" {
+		r.doFastForward(action)
+	} else if action.Command == "rewind" && action.Count != nil {
+		r.doMoveBy(action, -1)
 	} else if action.Command == "rewind" {
-		r.doRewind(action.Regex)
+		r.doRewind(action)
+	} else if action.Command == "seek" {
+		r.doSeek(action)
+	} else if action.Command == "pause" {
+		r.Paused = true
+	} else if action.Command == "play" {
+		r.Paused = false
 	}
 }
 
-func (r *Runner) doFastForward(target string) {
-	rule := r.applyVariablesToString(target)
-	re, err := regexp.Compile(rule)
+func (r *Runner) doFastForward(action *ast.MoveHeadAction) {
+	rule := r.applyVariablesToString(action.Regex)
+	re, err := r.compileRegex(rule)
 	if err != nil {
-		r.fatalError(err.Error(), nil)
+		r.fatalError(err.Error(), action)
+		return
 	}
 	line := ""
 	for ok := true; ok; ok = (!re.MatchString(line)) {
@@ -703,15 +1258,16 @@ func (r *Runner) doFastForward(target string) {
 		}
 		line = r.Tape.Text()
 	}
-	r.clearAndSetVariable("$@", line)
+	r.readRecord()
 	r.Tape.Prev()
 }
 
-func (r *Runner) doRewind(target string) {
-	rule := r.applyVariablesToString(target)
-	re, err := regexp.Compile(rule)
+func (r *Runner) doRewind(action *ast.MoveHeadAction) {
+	rule := r.applyVariablesToString(action.Regex)
+	re, err := r.compileRegex(rule)
 	if err != nil {
-		r.fatalError(err.Error(), nil)
+		r.fatalError(err.Error(), action)
+		return
 	}
 	line := ""
 	for ok := true; ok; ok = (!re.MatchString(line)) {
@@ -720,7 +1276,156 @@ func (r *Runner) doRewind(target string) {
 		}
 		line = r.Tape.Text()
 	}
-	r.clearAndSetVariable("$@", line)
+	r.readRecord()
+	r.Tape.Prev()
+}
+
+func (r *Runner) doMarkAction(action *ast.MarkAction) {
+	//In BEGIN there may be no tape yet, which is the same as being before its first record.
+	record := -1
+	if r.Tape != nil {
+		record = r.Tape.Offset()
+	}
+	r.Marks[action.Name] = Mark{File: r.FileIndex, Record: record}
+}
+
+// doEditTapeAction writes, inserts or deletes records on the tape. The
+// record being processed is still $_, so the output for this cycle does not
+// change; the edits are seen when the head next reads those records.
+func (r *Runner) doEditTapeAction(action *ast.EditTapeAction) {
+	if r.Tape == nil || r.Tape.Offset() < 0 {
+		r.fatalError("no record under the head to "+action.Command, action)
+		return
+	}
+	offset := r.Tape.Offset()
+	var record string
+	if action.Expression != nil {
+		val := r.evaluateExpression(action.Expression)
+		if val == nil {
+			r.fatalError("expression did not produce a value", action)
+			return
+		}
+		record = val.String()
+	}
+
+	switch action.Command {
+	case "write":
+		if !r.Tape.Write(record) {
+			break
+		}
+		return
+	case "insert":
+		if !r.Tape.Insert(record, action.After) {
+			break
+		}
+		if !action.After {
+			offset--
+		}
+		r.shiftMarks(offset, 1)
+		return
+	case "delete":
+		if !r.Tape.Delete() {
+			break
+		}
+		r.shiftMarks(offset, -1)
+		return
+	}
+	r.fatalError("no record under the head to "+action.Command, action)
+}
+
+// shiftMarks moves marks set in this file after record idx by n records, so
+// they stay on the same record when records are inserted or deleted before
+// them.
+func (r *Runner) shiftMarks(idx int, n int) {
+	for name, mark := range r.Marks {
+		if mark.File == r.FileIndex && mark.Record > idx {
+			mark.Record += n
+			r.Marks[name] = mark
+		}
+	}
+}
+
+// doRewindToMark moves the head back, or forward, to the record it was on
+// when the mark was set.
+func (r *Runner) doRewindToMark(action *ast.MoveHeadAction) {
+	mark, ok := r.Marks[action.Mark]
+	if !ok {
+		r.fatalError("no mark named "+action.Mark+" has been set", action)
+		return
+	} else if mark.File != r.FileIndex {
+		r.fatalError("mark "+action.Mark+" was set in another input file", action)
+		return
+	}
+	if _, err := r.Tape.Seek(max(mark.Record, 0), io.SeekStart); err != nil {
+		r.fatalError("mark "+action.Mark+" is no longer in the --history window", action)
+		return
+	}
+	if mark.Record < 0 {
+		//Marked before the first record was read, so start again from the top.
+		r.Tape.Prev()
+		return
+	}
+	r.readRecord()
+	r.Tape.Prev()
+}
+
+// doMoveBy moves the head a number of records forward, or backwards if
+// direction is -1.
+func (r *Runner) doMoveBy(action *ast.MoveHeadAction, direction int) {
+	count, err := r.convertToInt(r.evaluateExpression(action.Count))
+	if errors.Is(err, errIntRange) {
+		r.fatalError(action.Command+" count "+err.Error(), action)
+		return
+	}
+	if err != nil {
+		r.fatalError(action.Command+" expects a regex or a number of records", action)
+		return
+	}
+	r.seekRecord(r.Tape.Offset() + direction*count)
+}
+
+func (r *Runner) doSeek(action *ast.MoveHeadAction) {
+	if action.ToEnd {
+		if _, err := r.Tape.Seek(-1, io.SeekEnd); err != nil {
+			r.ShouldHalt = true
+			return
+		}
+		r.readRecord()
+		r.Tape.Prev()
+		return
+	}
+	record, err := r.convertToInt(r.evaluateExpression(action.Count))
+	if errors.Is(err, errIntRange) {
+		r.fatalError("seek record "+err.Error(), action)
+		return
+	}
+	if err != nil || record < 1 {
+		r.fatalError("seek expects a record number from 1, or end", action)
+		return
+	}
+	r.seekRecord(record - 1)
+}
+
+// seekRecord puts the head on the record at index idx, which becomes $@ and
+// is read again at the start of the next cycle, the same as after a
+// fastforward. Seeking before the first record stops at the first, and
+// seeking past the last halts.
+func (r *Runner) seekRecord(idx int) {
+	if idx < 0 {
+		idx = 0
+	}
+	_, err := r.Tape.Seek(idx, io.SeekStart)
+	if errors.Is(err, ErrBof) {
+		//Streamed input only remembers recent records, so stop at the oldest one kept.
+		for r.Tape.Prev() {
+		}
+		_, err = r.Tape.Seek(1, io.SeekCurrent)
+	}
+	if err != nil {
+		r.ShouldHalt = true
+		return
+	}
+	r.readRecord()
 	r.Tape.Prev()
 }
 
@@ -752,18 +1457,19 @@ func (r *Runner) fatalError(msg string, action ast.Action) {
 	if r.CurrState == "" {
 		r.CurrState = "INIT"
 	}
-	_, err := io.WriteString(r.OutputTape, "Runtime Error in state: "+r.CurrState+"\n")
-	if err != nil {
-		panic(err)
+	if action == nil {
+		action = r.currAction
 	}
-	if action != nil {
-		_, err = io.WriteString(r.OutputTape, "Action: "+action.String()+"\n")
-		if err != nil {
-			panic(err)
-		}
+	if r.Err == nil {
+		r.Err = r.newRuntimeError(msg, action)
 	}
-	_, err = io.WriteString(r.OutputTape, msg+"\n")
-	if err != nil {
-		panic(err)
+}
+
+// ioError stops the run because output could not be written.
+func (r *Runner) ioError(err error) {
+	r.ShouldHalt = true
+	r.DidFatalError = true
+	if r.Err == nil {
+		r.Err = &IOError{Err: err}
 	}
 }
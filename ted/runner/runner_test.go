@@ -0,0 +1,369 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ahalbert/ted/ted/lexer"
+	"github.com/ahalbert/ted/ted/parser"
+)
+
+// motivation is the program from the README, which exercises regex
+// matching, transitions and capturing on every record.
+const motivation = `
+startstate: /Starting.Procedure/ -> capture_begin
+capture_begin: {
+	start capture
+	-> lookforsuccessorending
+	/Success/ -> startstate
+}
+lookforsuccessorending: /Success/  -> startstate
+lookforsuccessorending: /Ending.Procedure/ {
+	stop capture
+	print
+	-> startstate
+}
+`
+
+const motivationInput = `INFO:2024-12-07 13:01:40:Trace:198d079c-af9a-45b2-8236-7fbb2a012f69:Starting Procedure foo
+ERROR:2024-12-07 13:01:41:Trace:198d079c-af9a-45b2-8236-7fbb2a012f69:Error 1
+INFO:2024-12-07 13:01:41:Trace:198d079c-af9a-45b2-8236-7fbb2a012f69:Ending Procedure foo
+INFO:2024-12-07 13:01:41:Trace:198d079c-af9a-45b2-8236-7fbb2a012f69:Starting Procedure bar
+INFO:2024-12-07 13:01:41:Trace:198d079c-af9a-45b2-8236-7fbb2a012f69:Error 2
+INFO:2024-12-07 13:01:41:Trace:198d079c-af9a-45b2-8236-7fbb2a012f69:Success
+INFO:2024-12-07 13:01:42:Trace:198d079c-af9a-45b2-8236-7fbb2a012f69:Ending Procedure bar
+`
+
+func benchmarkProgram(b *testing.B, program string, record string, vars map[string]string) {
+	fsa, errors := parser.New(lexer.New(program)).ParseFSA()
+	if len(errors) > 0 {
+		b.Fatal(errors)
+	}
+	const records = 10000
+	input := strings.Repeat(record, records/strings.Count(record, "\n"))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		variables := map[string]string{"$PRINTMODE": "noprint"}
+		for k, v := range vars {
+			variables[k] = v
+		}
+		r := NewRunner(fsa, variables)
+		r.RunFSAFromString(input, io.Discard)
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*records), "ns/record")
+}
+
+// runProgram runs program over input and returns what it printed. vars are
+// set over a $PRINTMODE of noprint.
+func runProgram(t *testing.T, program string, vars map[string]string, input string) (string, error) {
+	t.Helper()
+	fsa, errs := parser.New(lexer.New(program)).ParseFSA()
+	if len(errs) > 0 {
+		t.Fatalf("%q - parse errors: %v", program, errs)
+	}
+	variables := map[string]string{"$PRINTMODE": "noprint"}
+	for k, v := range vars {
+		variables[k] = v
+	}
+	r := NewRunner(fsa, variables)
+	r.Program = program
+	var out bytes.Buffer
+	err := r.RunFSAFromString(input, &out)
+	return out.String(), err
+}
+
+// isRuntimeError reports whether err is a *RuntimeError.
+func isRuntimeError(err error) bool {
+	var runtimeErr *RuntimeError
+	return errors.As(err, &runtimeErr)
+}
+
+func BenchmarkMotivation(b *testing.B) {
+	benchmarkProgram(b, motivation, motivationInput, nil)
+}
+
+func BenchmarkSed(b *testing.B) {
+	benchmarkProgram(b, `do s/Trace/trace/g`, motivationInput, map[string]string{"$PRINTMODE": "print"})
+}
+
+func BenchmarkTemplatedRegex(b *testing.B) {
+	benchmarkProgram(b, `/{{ .level }}/ capture`, motivationInput, map[string]string{"level": "ERROR"})
+}
+
+func TestRunFSAErrors(t *testing.T) {
+	tests := []struct {
+		program  string
+		output   string
+		exitCode int  // expected *ExitError status, 0 for none
+		runtime  bool // expect a *RuntimeError
+	}{
+		{`/b/ exit`, "a\nb\n", 0, false},
+		{`/b/ exit 3`, "a\nb\n", 3, false},
+		{`BEGIN: exit 4 END: println "end"`, "end\n", 4, false},
+		{`END: { println "end" exit 5 println "unreachable" }`, "a\nb\nc\nend\n", 5, false},
+		{`/b/ let x = $missing`, "a\n", 0, true},
+		{`/b/ println $missing`, "a\n", 0, true},
+		{`/b/ print $missing`, "a\n", 0, true},
+		{`exit "x"`, "", 0, true},
+	}
+
+	for i, tt := range tests {
+		out, err := runProgram(t, tt.program, map[string]string{"$PRINTMODE": "print"}, "a\nb\nc")
+		if out != tt.output {
+			t.Errorf("test[%d] - output wrong. expected=%q, got=%q", i, tt.output, out)
+		}
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) != (tt.exitCode != 0) || (exitErr != nil && exitErr.Code != tt.exitCode) {
+			t.Errorf("test[%d] - expected exit status %d, got err=%v", i, tt.exitCode, err)
+		}
+		if isRuntimeError(err) != tt.runtime {
+			t.Errorf("test[%d] - expected runtime error=%t, got err=%v", i, tt.runtime, err)
+		}
+	}
+}
+
+func TestRuntimeErrorPos(t *testing.T) {
+	tests := []struct {
+		program   string
+		column    int
+		underline string
+	}{
+		{`let x = 1 / 0`, 11, "        ~~^~~"},
+		{`let x = 7 % 0`, 11, "        ~~^~~"},
+		{`let x = 2 * "b"`, 11, "        ~~^~~~~"},
+		{`let x = 2 + 1 - "b"`, 15, "        ~~~~~~^~~~~"},
+		{`let x = [1] < 2`, 13, "        ~~~~^~~"},
+		{`let x = $missing`, 9, "        ^~~~~~~~"},
+	}
+
+	for i, tt := range tests {
+		_, err := runProgram(t, tt.program, nil, "a")
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Errorf("test[%d] - expected a runtime error, got err=%v", i, err)
+			continue
+		}
+		if runtimeErr.Pos.LineNum != 1 || runtimeErr.Pos.Position != tt.column {
+			t.Errorf("test[%d] - error at %d:%d, want 1:%d", i, runtimeErr.Pos.LineNum, runtimeErr.Pos.Position, tt.column)
+		}
+		if !strings.Contains(err.Error(), "| "+tt.underline+"\n") {
+			t.Errorf("test[%d] - expected the error to underline %q, got %q", i, tt.underline, err.Error())
+		}
+	}
+}
+
+func TestMarks(t *testing.T) {
+	tests := []struct {
+		program string
+		output  string
+	}{
+		{`a: /b/ { mark here -> b } b: /d/ { rewind to here -> c } c: { println $@ -> d } d: -> d`, "b\n"},
+		{`BEGIN: mark top a: /c/ { goto mark top -> b } b: { println $@ } b: /b/ -> c c: -> c`, "a\nb\n"},
+		{`a: /a/ { mark first fastforward 2 println $@ goto mark first println $@ -> b } b: -> b`, "c\na\n"},
+		{`a: /c/ { mark here insert before "x" rewind to here -> b } b: println $@`, "c\nd\n"},
+		{`a: /d/ { mark here rewind 2 -> b } b: { delete rewind to here -> c } c: println $@`, "d\n"},
+	}
+
+	for i, tt := range tests {
+		out, err := runProgram(t, tt.program, nil, "a\nb\nc\nd")
+		if err != nil {
+			t.Errorf("test[%d] - unexpected error: %v", i, err)
+		}
+		if out != tt.output {
+			t.Errorf("test[%d] - output wrong. expected=%q, got=%q", i, tt.output, out)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	tests := []struct {
+		program string
+		vars    map[string]string
+		input   string
+		output  string
+	}{
+		{`println $F2`, nil, "  a  b   c ", "b\n"},
+		{`println $NF`, nil, "a b c\n", "3\n0\n"},
+		{`println $F4`, nil, "a b c", "\n"},
+		{`println $F3`, map[string]string{"$FS": ":"}, "a::c", "c\n"},
+		{`println $F3`, map[string]string{"$FS": "[0-9]+", "$FSMODE": "regex"}, "a1b22c", "c\n"},
+		{`{ let $F2 = "B" println $_ }`, map[string]string{"$FS": ":", "$OFS": "-"}, "a:b:c", "a-B-c\n"},
+		{`{ let $F5 = "e" println $_ }`, nil, "a b c", "a b c  e\n"},
+		{`{ let $NF = 2 println $_ println $F3 }`, nil, "a b c", "a b\n\n"},
+		{`{ do "s/a/x y/" println $F2 }`, nil, "a b", "y\n"},
+		{`{ println $.b println $NR }`, map[string]string{"$FORMAT": "csv"}, "a,b\n1,\"x,\ny\"", "x,\ny\n1\n"},
+		{`{ let $.a = "p q,r" println $_ }`, map[string]string{"$FORMAT": "csv"}, "a,b\n1,2", "\"p q,r\",2\n"},
+		{`println $F2`, map[string]string{"$FORMAT": "tsv"}, "a\tb\n1\t\"2\t3\"", "2\t3\n"},
+		{`{ println $.a.b println $.a.b.1.c println $.missing.x }`, map[string]string{"$FORMAT": "jsonl"}, `{"a":{"b":[1,{"c":"d"}]}}`, "[1,{\"c\":\"d\"}]\nd\n\n"},
+		{`{ let $.a.n = "2" let $.s = "x" let $.t.u = "3" println $_ }`, map[string]string{"$FORMAT": "jsonl"}, `{"s":1,"a":{"n":1.5},"z":"<&>"}`, `{"s":"x","a":{"n":2},"z":"<&>","t":{"u":"3"}}` + "\n"},
+	}
+
+	for i, tt := range tests {
+		out, err := runProgram(t, tt.program, tt.vars, tt.input)
+		if err != nil {
+			t.Errorf("test[%d] - unexpected error: %v", i, err)
+		}
+		if out != tt.output {
+			t.Errorf("test[%d] - output wrong. expected=%q, got=%q", i, tt.output, out)
+		}
+	}
+}
+
+func TestCollections(t *testing.T) {
+	tests := []struct {
+		program string
+		output  string
+		runtime bool // expect a *RuntimeError
+	}{
+		{`main: /(.)/ let counts[$1] = counts[$1] + 1 END: for k in counts { print k println counts[k] }`, "a2\nb1\n", false},
+		{`main: /(.)/ { if $1 in seen println $_ let seen[$1] = true }`, "a2\n", false},
+		{`END: { let l = [1, "two"] let l[len(l)] = 3 delete l[0] println l println len(l) }`, "[two, 3]\n2\n", false},
+		{`END: { let k = "a" let m = [k: 1, "b": 2] delete m["a"] println m println "a" in m }`, "[b: 2]\nfalse\n", false},
+		{`END: { let m = [:] let n = m let n["x"] = 1 println len(m) println len(never) }`, "0\n0\n", false},
+		{`END: { clear seen let seen["x"] = 1 println seen for v in ["p", "q"] print v println "" }`, "[x: 1]\npq\n", false},
+		{`END: { let l = [1] println l[1] }`, "", true},
+		{`END: { let s = "x" let s["k"] = 1 }`, "", true},
+		{`END: { let l = [[1]] }`, "", true},
+	}
+
+	for i, tt := range tests {
+		out, err := runProgram(t, tt.program, nil, "a1\nb\na2")
+		if isRuntimeError(err) != tt.runtime {
+			t.Errorf("test[%d] - expected runtime error=%t, got err=%v", i, tt.runtime, err)
+		}
+		if out != tt.output {
+			t.Errorf("test[%d] - output wrong. expected=%q, got=%q", i, tt.output, out)
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		program string
+		output  string
+		runtime bool // expect a *RuntimeError
+	}{
+		{`END: println 7 % 3 .. "," .. (2 <= 2) .. "," .. (3 >= 4)`, "1,true,false\n", false},
+		{`END: println "a" .. 1 + 2`, "a3\n", false},
+		{`END: if 1 < 2 && "b" >= "a" || missing() println "yes"`, "yes\n", false},
+		{`END: if false && missing() println "no" else println "short"`, "short\n", false},
+		{`END: println !"" .. !0 .. !"x"`, "truetruefalse\n", false},
+		{`main: if $_ ~ /^(b|c)$/ println $1 END: if "a" !~ /b/ println "none"`, "b\nc\nnone\n", false},
+		{`main: { let p = "^c" if $_ ~ p println $_ }`, "c\n", false},
+		{`main: if !($_ in seen) { let seen[$_] = 1 println $_ } END: println len(seen)`, "a\nb\nc\n3\n", false},
+		{`END: println 1 % 0`, "", true},
+	}
+
+	for i, tt := range tests {
+		out, err := runProgram(t, tt.program, nil, "a\nb\nc")
+		if isRuntimeError(err) != tt.runtime {
+			t.Errorf("test[%d] - expected runtime error=%t, got err=%v", i, tt.runtime, err)
+		}
+		if out != tt.output {
+			t.Errorf("test[%d] - output wrong. expected=%q, got=%q", i, tt.output, out)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		program string
+		vars    map[string]string
+		output  string
+		runtime bool // expect a *RuntimeError
+	}{
+		{`BEGIN: let total = 0 main: let total = total + $F2 END: { println total println total / 3 }`, nil, "22.75\n7.583333333333333\n", false},
+		{`END: { println 7 / 2 println 6 / 3 println -2.5 * 2 println 0.5 + 0.25 println 7.5 % 2 }`, nil, "3.5\n2\n-5\n0.75\n1.5\n", false},
+		{`END: println 9223372036854775807 * 2 > 9223372036854775807`, nil, "true\n", false},
+		{`END: { println 1.5 > 1.25 println "10" == 10.0 println 9 < 10 println "9ms" < "10ms" }`, nil, "true\ntrue\ntrue\nfalse\n", false},
+		{`END: { let x = 10 / 3 println x println x * 3 }`, map[string]string{"$OFMT": "%.2f"}, "3.33\n9.99\n", false},
+		{`END: println -$F2`, nil, "-7.25\n", false},
+		{`END: println 1 / 0`, nil, "", true},
+		{`END: println 1.5 % 0`, nil, "", true},
+		{`END: println "abc" + 1`, nil, "", true},
+		{`END: println -"x"`, nil, "", true},
+		{`END: { seek 3000000000.0 println "past" }`, nil, "past\n", false},
+		{`END: seek 30000000000000000000000.0`, nil, "", true},
+	}
+
+	for i, tt := range tests {
+		out, err := runProgram(t, tt.program, tt.vars, "a 12.5ms\nb 3ms\nc 7.25ms")
+		if isRuntimeError(err) != tt.runtime {
+			t.Errorf("test[%d] - expected runtime error=%t, got err=%v", i, tt.runtime, err)
+		}
+		if out != tt.output {
+			t.Errorf("test[%d] - output wrong. expected=%q, got=%q", i, tt.output, out)
+		}
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []struct {
+		program string
+		output  string
+		runtime bool // expect a *RuntimeError
+	}{
+		{`END: println substr("héllo", 1, 3) .. "," .. substr("hello", 3) .. "," .. substr("hi", 5, 2)`, "éll,lo,\n", false},
+		{`END: println upper("a") .. lower("B") .. "[" .. trim(" c ") .. "]"`, "Ab[c]\n", false},
+		{`main: { let parts = split($_, ",") println len(parts) .. " " .. parts[1] .. " " .. join(parts, "-") }`, "3 b a-b-c\n2 y x-y\n", false},
+		{`END: println split(" a  b ") .. join([:]) .. join(["x": 1, "y": 2], "+")`, "[a, b]x+y\n", false},
+		{`END: println index("a,b,c", ",c") .. " " .. index(["a", "b"], "b") .. " " .. index("abc", "z")`, "3 1 -1\n", false},
+		{`END: println replace("a.b.c", ".", "/")`, "a/b/c\n", false},
+		{`END: println sprintf("%-3s|%5.2f|%03d|%x|%*d", "a", "12.345ms", 7.9, 255, 3, 1)`, "a  |12.35|007|ff|  1\n", false},
+		{`END: println abs(-3) .. " " .. abs("-2.5") .. " " .. min(3, 1.5, 2) .. " " .. max(3, "10", 2)`, "3 2.5 1.5 10\n", false},
+		{`END: println int("12.9ms") .. " " .. num("12.5ms") + 1 .. " " .. str(1.5)`, "12 13.5 1.5\n", false},
+		{`main: if match($_, /,(\w)$/) >= 0 println $1 END: println match("abc", /z/)`, "c\ny\n-1\n", false},
+		{`main: println gsub($_, /(\w)/, "<$1>")`, "<a>,<b>,<c>\n<x>,<y>\n", false},
+		{`function upper(s) { return "mine" } END: println upper("a")`, "mine\n", false},
+		{`END: println sprintf("%d %d", 1)`, "", true},
+		{`END: println substr("x")`, "", true},
+		{`END: println abs("x")`, "", true},
+		{`END: println upper([1])`, "", true},
+		{`END: println match("a", "(")`, "", true},
+	}
+
+	for i, tt := range tests {
+		out, err := runProgram(t, tt.program, nil, "a,b,c\nx,y")
+		if isRuntimeError(err) != tt.runtime {
+			t.Errorf("test[%d] - expected runtime error=%t, got err=%v", i, tt.runtime, err)
+		}
+		if out != tt.output {
+			t.Errorf("test[%d] - output wrong. expected=%q, got=%q", i, tt.output, out)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		program string
+		vars    map[string]string
+		output  string
+		runtime bool // expect a *RuntimeError
+	}{
+		{`END: { let n = 0 while true { let n = n + 1 if n == 2 continue if n == 4 break println n } }`, nil, "1\n3\n", false},
+		{`END: for x in [1, 2] for y in [1, 2] { if y == 2 break print x println y }`, nil, "11\n21\n", false},
+		{`main: /a/ start capture lines END: for line in lines { if line == "b" continue println line }`, nil, "a\nc\n", false},
+		{`END: { let s = "x;y;" for line in s println line }`, map[string]string{"$RS": ";"}, "x\ny\n", false},
+		{`function f() { for x in [1, 2, 3] { if x == 2 return x } } END: println f()`, nil, "2\n", false},
+		{`END: while true let x = 1`, map[string]string{"$LOOPLIMIT": "3"}, "", true},
+		{`END: { let n = 0 while true { let n = n + 1 if n == 5 break } println n }`, map[string]string{"$LOOPLIMIT": "0"}, "5\n", false},
+		{`END: while "x" println 1`, nil, "", true},
+		{`END: break`, nil, "", true},
+		{`main: pause`, map[string]string{"$LOOPLIMIT": "3"}, "", true},
+		{`BEGIN: let n = 0 main: /a/ { let n = n + 1 if n == 3 play else pause } END: println n`, map[string]string{"$LOOPLIMIT": "3"}, "3\n", false},
+		{`function f() { continue } END: for x in [1] f()`, nil, "", true},
+	}
+
+	for i, tt := range tests {
+		out, err := runProgram(t, tt.program, tt.vars, "a\nb\nc")
+		if isRuntimeError(err) != tt.runtime {
+			t.Errorf("test[%d] - expected runtime error=%t, got err=%v", i, tt.runtime, err)
+		}
+		if out != tt.output {
+			t.Errorf("test[%d] - output wrong. expected=%q, got=%q", i, tt.output, out)
+		}
+	}
+}
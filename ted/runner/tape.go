@@ -1,8 +1,12 @@
 package runner
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"io"
+	"regexp"
+	"slices"
 	"strings"
 	"unicode/utf8"
 
@@ -11,48 +15,223 @@ import (
 
 type Tape interface {
 	Split(seperator string)
+	// SplitFunc is Split with a function that finds the seperator, such as
+	// RegexSeperator or CSVSeperator.
+	SplitFunc(fn SeperatorFunc)
 	Scan() bool
 	Text() string
+	// Terminator returns the seperator that ended the record under the head,
+	// or "" for a last record that wasn't followed by one.
+	Terminator() string
+	Offset() int     // index of the record under the head, -1 before the first
+	ByteOffset() int // where the record under the head starts in the input, in bytes
 	Seek(int, int) (int, error)
 	Prev() bool
 	Next() bool
+	// Write replaces the record under the head. It returns false if the head
+	// is not on a record.
+	Write(record string) bool
+	// Insert adds a record before or after the one under the head, which
+	// stays under the head.
+	Insert(record string, after bool) bool
+	// Delete removes the record under the head. The head is left on the
+	// record before it, so Next reads the record that followed.
+	Delete() bool
 }
 
 var ErrEof = errors.New("EOF")
 var ErrBof = errors.New("BOF")
 
+// SeperatorFunc returns where the first seperator in a record begins and
+// ends, or false if there is none. It reads the record from its start
+// through in, and only as far as it needs to, so a tape reading a stream
+// doesn't search what it has already read again as more arrives.
+type SeperatorFunc func(in RecordReader) (int, int, bool)
+
+// RecordReader reads a record, from its start, for a SeperatorFunc. Seek
+// offsets are in bytes from the start of the record.
+type RecordReader interface {
+	io.RuneReader
+	io.Seeker
+}
+
+// RegexSeperator finds seperators matched by re. If re has a group, only the
+// text matched by the first group is the seperator, and the rest of the
+// match stays in the next record. Empty matches are skipped, since they
+// would never move the tape forward.
+func RegexSeperator(re *regexp.Regexp) SeperatorFunc {
+	return func(in RecordReader) (int, int, bool) {
+		for from := 0; ; {
+			if _, err := in.Seek(int64(from), io.SeekStart); err != nil {
+				return 0, 0, false
+			}
+			loc := re.FindReaderSubmatchIndex(in)
+			if loc == nil {
+				return 0, 0, false
+			}
+			begin, end := loc[0], loc[1]
+			if len(loc) > 2 && loc[2] >= 0 {
+				begin, end = loc[2], loc[3]
+			}
+			if end > begin {
+				return from + begin, from + end, true
+			}
+			//Look again from the rune after the empty match.
+			from += loc[0]
+			if _, err := in.Seek(int64(from), io.SeekStart); err != nil {
+				return 0, 0, false
+			}
+			_, size, err := in.ReadRune()
+			if err != nil {
+				return 0, 0, false
+			}
+			from += size
+		}
+	}
+}
+
+// CSVSeperator finds the end of a CSV or TSV row: the first newline, or
+// \r\n, that isn't inside double quotes.
+func CSVSeperator(in RecordReader) (int, int, bool) {
+	quoted := false
+	var prev rune
+	for pos := 0; ; {
+		c, size, err := in.ReadRune()
+		if err != nil {
+			return 0, 0, false
+		}
+		if c == '"' {
+			quoted = !quoted
+		} else if c == '\n' && !quoted {
+			if prev == '\r' {
+				return pos - 1, pos + 1, true
+			}
+			return pos, pos + 1, true
+		}
+		prev = c
+		pos += size
+	}
+}
+
 type StringTape struct {
 	input     string
 	groups    []string
+	starts    []int
+	terms     []string
 	offset    int
 	seperator string
 }
 
 func NewStringTape(in string) *StringTape {
-	return &StringTape{input: in,
-		offset:    -1,
-		seperator: "\n",
-		groups:    strings.Split(in, "\n"),
-	}
+	ss := &StringTape{input: in, offset: -1}
+	ss.Split("\n")
+	return ss
 }
 
 func (ss *StringTape) Text() string {
 	return ss.groups[ss.offset]
 }
 
+func (ss *StringTape) Offset() int {
+	return ss.offset
+}
+
+func (ss *StringTape) ByteOffset() int {
+	if ss.offset < 0 || ss.offset >= len(ss.starts) {
+		return -1
+	}
+	return ss.starts[ss.offset]
+}
+
+func (ss *StringTape) Terminator() string {
+	if ss.offset < 0 || ss.offset >= len(ss.terms) {
+		return ""
+	}
+	return ss.terms[ss.offset]
+}
+
 func (ss *StringTape) Split(seperator string) {
 	ss.seperator = seperator
 	ss.groups = strings.Split(ss.input, ss.seperator)
+	ss.starts = make([]int, len(ss.groups))
+	ss.terms = make([]string, len(ss.groups))
+	pos := 0
+	for i, group := range ss.groups {
+		ss.starts[i] = pos
+		pos += len(group) + len(ss.seperator)
+		if i < len(ss.groups)-1 {
+			ss.terms[i] = ss.seperator
+		}
+	}
 }
 
-func (ss *StringTape) Prev() bool {
-	if ss.offset <= 0 {
+// SplitFunc splits the input on the seperators fn finds. Unlike Split, a
+// seperator at the end of the input does not leave an empty record after it.
+func (ss *StringTape) SplitFunc(fn SeperatorFunc) {
+	ss.groups, ss.starts, ss.terms = nil, nil, nil
+	for pos := 0; pos < len(ss.input); {
+		begin, end, ok := fn(strings.NewReader(ss.input[pos:]))
+		if !ok {
+			begin, end = len(ss.input)-pos, len(ss.input)-pos
+		}
+		ss.groups = append(ss.groups, ss.input[pos:pos+begin])
+		ss.starts = append(ss.starts, pos)
+		ss.terms = append(ss.terms, ss.input[pos+begin:pos+end])
+		pos += end
+	}
+}
+
+// onRecord reports whether the head is on a record that Write, Insert and
+// Delete can act on.
+func (ss *StringTape) onRecord() bool {
+	return ss.offset >= 0 && ss.offset < len(ss.groups)
+}
+
+func (ss *StringTape) Write(record string) bool {
+	if !ss.onRecord() {
+		return false
+	}
+	ss.groups[ss.offset] = record
+	return true
+}
+
+func (ss *StringTape) Insert(record string, after bool) bool {
+	if !ss.onRecord() {
+		return false
+	}
+	idx, term := ss.offset, ss.terms[ss.offset]
+	if after {
+		idx++
+	} else {
+		ss.offset++
+	}
+	ss.groups = slices.Insert(ss.groups, idx, record)
+	ss.starts = slices.Insert(ss.starts, idx, -1)
+	ss.terms = slices.Insert(ss.terms, idx, term)
+	return true
+}
+
+func (ss *StringTape) Delete() bool {
+	if !ss.onRecord() {
 		return false
 	}
+	ss.groups = slices.Delete(ss.groups, ss.offset, ss.offset+1)
+	ss.starts = slices.Delete(ss.starts, ss.offset, ss.offset+1)
+	ss.terms = slices.Delete(ss.terms, ss.offset, ss.offset+1)
 	ss.offset--
 	return true
 }
 
+// Prev moves the head back one record. Moving back from the first record
+// leaves the head before it, like the other tapes, and returns false.
+func (ss *StringTape) Prev() bool {
+	if ss.offset < 0 {
+		return false
+	}
+	ss.offset--
+	return ss.offset >= 0
+}
+
 func (ss *StringTape) Next() bool {
 	ss.offset++
 	if ss.offset >= len(ss.groups) {
@@ -90,41 +269,198 @@ func (ss *StringTape) Seek(offset int, whence int) (int, error) {
 	return newOffset, nil
 }
 
-type stringPosition struct {
-	begin int
-	end   int
-}
-
+// ReversibleScanner is a tape over a memory mapped file. Records are found
+// with bytes.Index as the head first reaches them, and the start of every
+// record seen so far is remembered so the head can move backwards cheaply.
+// With a SplitFunc, where each record ends is remembered as well.
+//
+// The file itself is never written. Records written over are kept in
+// written, and once a record is inserted or deleted, order maps each record
+// on the tape to a record in the file or to one of inserted.
 type ReversibleScanner struct {
 	mmap      mmap.MMap
 	pos       int
 	curr      string
-	offsets   map[int]stringPosition
-	seperator string
+	starts    []int
+	lastEnd   int
+	seperator []byte
 	offset    int
-	maxOffset int
 	readAll   bool
+	split     SeperatorFunc
+	ends      []int          // end of each record, only kept with a SplitFunc
+	written   map[int]string // records in the file written over; nil until written
+	order     []int          // record on the tape -> record in the file, or -1-i for inserted[i]; nil until needed
+	inserted  []string
+	insTerms  []string // terminator of each of inserted
 }
 
 func NewReversibleScanner(m mmap.MMap) *ReversibleScanner {
-	ofs := make(map[int]stringPosition)
 	return &ReversibleScanner{mmap: m,
 		pos:       0,
-		offsets:   ofs,
-		seperator: "\n",
+		seperator: []byte("\n"),
 		readAll:   false,
 		offset:    -1,
-		maxOffset: -1,
 	}
 }
 
+func (rs *ReversibleScanner) Offset() int {
+	return rs.offset
+}
+
+func (rs *ReversibleScanner) ByteOffset() int {
+	if rs.offset < 0 || rs.offset >= rs.scanned() {
+		return -1
+	}
+	idx := rs.physical(rs.offset)
+	if idx < 0 {
+		return -1
+	}
+	return rs.starts[idx]
+}
+
+// scanned is the number of records on the tape found so far.
+func (rs *ReversibleScanner) scanned() int {
+	if rs.order != nil {
+		return len(rs.order)
+	}
+	return len(rs.starts)
+}
+
+// physical returns the record in the file at tape position idx, or -1 if it
+// was inserted.
+func (rs *ReversibleScanner) physical(idx int) int {
+	if rs.order == nil {
+		return idx
+	}
+	if rs.order[idx] < 0 {
+		return -1
+	}
+	return rs.order[idx]
+}
+
+// text returns the record at tape position idx.
+func (rs *ReversibleScanner) text(idx int) string {
+	phys := rs.physical(idx)
+	if phys < 0 {
+		return rs.inserted[-1-rs.order[idx]]
+	}
+	if record, ok := rs.written[phys]; ok {
+		return record
+	}
+	begin, end := rs.bounds(phys)
+	return string(rs.mmap[begin:end])
+}
+
+func (rs *ReversibleScanner) Terminator() string {
+	if rs.offset < 0 || rs.offset >= rs.scanned() {
+		return ""
+	}
+	idx := rs.physical(rs.offset)
+	if idx < 0 {
+		return rs.insTerms[-1-rs.order[rs.offset]]
+	}
+	_, end := rs.bounds(idx)
+	next := rs.pos
+	if idx+1 < len(rs.starts) {
+		next = rs.starts[idx+1]
+	}
+	return string(rs.mmap[end:next])
+}
+
+// reorder starts mapping records on the tape to records in the file, so
+// records can be inserted and deleted.
+func (rs *ReversibleScanner) reorder() {
+	if rs.order != nil {
+		return
+	}
+	rs.order = make([]int, len(rs.starts))
+	for i := range rs.order {
+		rs.order[i] = i
+	}
+}
+
+func (rs *ReversibleScanner) onRecord() bool {
+	return rs.offset >= 0 && rs.offset < rs.scanned()
+}
+
+func (rs *ReversibleScanner) Write(record string) bool {
+	if !rs.onRecord() {
+		return false
+	}
+	if phys := rs.physical(rs.offset); phys < 0 {
+		rs.inserted[-1-rs.order[rs.offset]] = record
+	} else {
+		if rs.written == nil {
+			rs.written = make(map[int]string)
+		}
+		rs.written[phys] = record
+	}
+	rs.curr = record
+	return true
+}
+
+func (rs *ReversibleScanner) Insert(record string, after bool) bool {
+	if !rs.onRecord() {
+		return false
+	}
+	term := rs.Terminator()
+	rs.reorder()
+	rs.inserted = append(rs.inserted, record)
+	rs.insTerms = append(rs.insTerms, term)
+	idx := rs.offset
+	if after {
+		idx++
+	} else {
+		rs.offset++
+	}
+	rs.order = slices.Insert(rs.order, idx, -len(rs.inserted))
+	return true
+}
+
+func (rs *ReversibleScanner) Delete() bool {
+	if !rs.onRecord() {
+		return false
+	}
+	rs.reorder()
+	rs.order = slices.Delete(rs.order, rs.offset, rs.offset+1)
+	rs.offset--
+	if rs.offset >= 0 {
+		rs.curr = rs.text(rs.offset)
+	} else {
+		rs.curr = ""
+	}
+	return true
+}
+
+// Split sets the record seperator and moves the head back before the first
+// record. An empty seperator is ignored.
 func (rs *ReversibleScanner) Split(sep string) {
+	if sep != "" {
+		rs.seperator = []byte(sep)
+	}
+	rs.split = nil
+	rs.reset()
+}
+
+// SplitFunc sets a function to find record seperators and moves the head
+// back before the first record.
+func (rs *ReversibleScanner) SplitFunc(fn SeperatorFunc) {
+	rs.split = fn
+	rs.reset()
+}
+
+func (rs *ReversibleScanner) reset() {
 	rs.pos = 0
-	rs.seperator = sep
-	rs.offsets = make(map[int]stringPosition)
+	rs.curr = ""
+	rs.starts = rs.starts[:0]
+	rs.lastEnd = 0
 	rs.readAll = false
 	rs.offset = -1
-	rs.maxOffset = -1
+	rs.written = nil
+	rs.ends = rs.ends[:0]
+	rs.order = nil
+	rs.inserted = nil
+	rs.insTerms = nil
 }
 
 func (rs *ReversibleScanner) Text() string {
@@ -163,76 +499,328 @@ func (rs *ReversibleScanner) Seek(offset int, whence int) (int, error) {
 	case io.SeekEnd:
 		for rs.Scan() {
 		}
-		whenceOffset = rs.maxOffset
+		whenceOffset = rs.scanned()
 	}
 	newOffset := offset + whenceOffset
 	if newOffset < 0 {
 		return 0, ErrBof
 	}
-	if newOffset > rs.maxOffset {
-		for rs.Scan() && newOffset > rs.maxOffset {
-		}
-		if newOffset > rs.maxOffset {
+	for newOffset >= rs.scanned() {
+		if !rs.Scan() {
 			return 0, ErrEof
 		}
 	}
-	position, ok := rs.offsets[newOffset]
-	if ok {
-		rs.offset = newOffset
-		rs.curr = string(rs.mmap[position.begin:position.end])
-		return int(position.begin), nil
+	rs.offset = newOffset
+	rs.curr = rs.text(newOffset)
+	return newOffset, nil
+}
+
+// bounds returns the byte range of record idx, excluding its seperator.
+func (rs *ReversibleScanner) bounds(idx int) (int, int) {
+	if rs.split != nil {
+		return rs.starts[idx], rs.ends[idx]
 	}
-	return 0, errors.New("Could not find offset")
+	if idx+1 < len(rs.starts) {
+		return rs.starts[idx], rs.starts[idx+1] - len(rs.seperator)
+	}
+	return rs.starts[idx], rs.lastEnd
 }
 
+// Scan finds the next record after the last one scanned. A trailing
+// seperator at the end of the input does not start an empty record.
 func (rs *ReversibleScanner) Scan() bool {
-	if rs.readAll {
+	if rs.readAll || rs.pos >= len(rs.mmap) {
+		rs.readAll = true
 		return false
 	}
-	rs.curr = ""
-	var begin int
-	currentPos, ok := rs.offsets[rs.maxOffset]
-	if ok {
-		begin = int(currentPos.end) + len(rs.seperator)
-		rs.pos = begin
+	begin := rs.pos
+	if rs.split != nil {
+		sepBegin, sepEnd, ok := rs.split(bytes.NewReader(rs.mmap[begin:]))
+		if !ok {
+			rs.lastEnd = len(rs.mmap)
+			rs.pos = len(rs.mmap)
+			rs.readAll = true
+		} else {
+			rs.lastEnd = begin + sepBegin
+			rs.pos = begin + sepEnd
+		}
+		rs.ends = append(rs.ends, rs.lastEnd)
+	} else if idx := bytes.Index(rs.mmap[begin:], rs.seperator); idx < 0 {
+		rs.lastEnd = len(rs.mmap)
+		rs.pos = len(rs.mmap)
+		rs.readAll = true
 	} else {
-		begin = 0
+		rs.lastEnd = begin + idx
+		rs.pos = rs.lastEnd + len(rs.seperator)
 	}
-	end := begin
-	for rs.curr[max(0, len(rs.curr)-len(rs.seperator)):] != rs.seperator {
-		nextRune, size, err := rs.readRune()
-		if err != nil {
-			rs.readAll = true
-			if errors.Is(err, ErrEof) {
-				return false
-			}
-			if begin == end {
-				return false
-			}
+	rs.starts = append(rs.starts, begin)
+	if rs.order != nil {
+		rs.order = append(rs.order, len(rs.starts)-1)
+	}
+	return true
+}
+
+// StreamTape reads records lazily from an io.Reader, so output can be
+// produced while input is still arriving. Only the most recent records are
+// kept for moving the head backwards; rewinding past the start of that
+// window behaves like rewinding past the beginning of the input.
+type StreamTape struct {
+	reader    *bufio.Reader
+	history   []string
+	starts    []int    // byte offset of each record in history
+	terms     []string // terminator of each record in history
+	read      int      // bytes read so far
+	base      int
+	offset    int
+	limit     int
+	seperator string
+	split     SeperatorFunc
+	pending   []byte // read but not yet split into records, with a SplitFunc
+	eof       bool
+	err       error
+}
+
+// NewStreamTape returns a tape over in that remembers at most history
+// records. A history of 0 or less keeps every record.
+func NewStreamTape(in io.Reader, history int) *StreamTape {
+	return &StreamTape{reader: bufio.NewReader(in),
+		offset:    -1,
+		limit:     history,
+		seperator: "\n",
+	}
+}
+
+func (st *StreamTape) Text() string {
+	if st.offset < st.base || st.offset >= st.base+len(st.history) {
+		return ""
+	}
+	return st.history[st.offset-st.base]
+}
+
+func (st *StreamTape) Offset() int {
+	return st.offset
+}
+
+func (st *StreamTape) ByteOffset() int {
+	if st.offset < st.base || st.offset >= st.base+len(st.starts) {
+		return -1
+	}
+	return st.starts[st.offset-st.base]
+}
+
+func (st *StreamTape) Terminator() string {
+	if st.offset < st.base || st.offset >= st.base+len(st.terms) {
+		return ""
+	}
+	return st.terms[st.offset-st.base]
+}
+
+// Split sets the record seperator. It has no effect once reading has begun.
+func (st *StreamTape) Split(seperator string) {
+	if seperator == "" || st.base+len(st.history) > 0 {
+		return
+	}
+	st.seperator = seperator
+	st.split = nil
+}
+
+// SplitFunc sets a function to find record seperators. It has no effect
+// once reading has begun.
+func (st *StreamTape) SplitFunc(fn SeperatorFunc) {
+	if st.base+len(st.history) > 0 {
+		return
+	}
+	st.split = fn
+}
+
+func (st *StreamTape) Prev() bool {
+	st.offset--
+	if st.offset < st.base {
+		st.offset = st.base - 1
+		return false
+	}
+	return true
+}
+
+func (st *StreamTape) Next() bool {
+	st.offset++
+	for st.offset >= st.base+len(st.history) {
+		if !st.Scan() {
+			return false
 		}
-		rs.curr += string(nextRune)
-		rs.pos += size
-		end += size
 	}
-	rs.maxOffset++
-	rs.curr = rs.curr[:len(rs.curr)-len(rs.seperator)]
-	rs.offsets[rs.maxOffset] = stringPosition{begin: begin, end: end - len(rs.seperator)}
 	return true
 }
 
-func (rs *ReversibleScanner) readRune() (rune, int, error) {
-	if rs.pos >= len(rs.mmap) {
-		return rune(0), 0, ErrEof
+func (st *StreamTape) Seek(offset int, whence int) (int, error) {
+	var whenceOffset int
+	switch whence {
+	case io.SeekStart:
+		whenceOffset = 0
+	case io.SeekCurrent:
+		whenceOffset = st.offset
+	case io.SeekEnd:
+		for st.Scan() {
+		}
+		whenceOffset = st.base + len(st.history)
+	}
+	newOffset := offset + whenceOffset
+	if newOffset < st.base {
+		return 0, ErrBof
+	}
+	for newOffset >= st.base+len(st.history) {
+		if !st.Scan() {
+			return 0, ErrEof
+		}
+	}
+	st.offset = newOffset
+	return newOffset, nil
+}
+
+func (st *StreamTape) onRecord() bool {
+	return st.offset >= st.base && st.offset < st.base+len(st.history)
+}
+
+func (st *StreamTape) Write(record string) bool {
+	if !st.onRecord() {
+		return false
+	}
+	st.history[st.offset-st.base] = record
+	return true
+}
+
+func (st *StreamTape) Insert(record string, after bool) bool {
+	if !st.onRecord() {
+		return false
+	}
+	idx := st.offset - st.base
+	term := st.terms[idx]
+	if after {
+		idx++
+	} else {
+		st.offset++
+	}
+	st.history = slices.Insert(st.history, idx, record)
+	st.starts = slices.Insert(st.starts, idx, -1)
+	st.terms = slices.Insert(st.terms, idx, term)
+	if st.limit > 0 && len(st.history) > st.limit && st.offset > st.base {
+		st.history = st.history[1:]
+		st.starts = st.starts[1:]
+		st.terms = st.terms[1:]
+		st.base++
+	}
+	return true
+}
+
+func (st *StreamTape) Delete() bool {
+	if !st.onRecord() {
+		return false
+	}
+	idx := st.offset - st.base
+	st.history = slices.Delete(st.history, idx, idx+1)
+	st.starts = slices.Delete(st.starts, idx, idx+1)
+	st.terms = slices.Delete(st.terms, idx, idx+1)
+	st.offset--
+	return true
+}
+
+// Scan reads one more record from the underlying reader into the history,
+// discarding the oldest record if the history is full.
+func (st *StreamTape) Scan() bool {
+	start := st.read - len(st.pending)
+	var record, term string
+	var ok bool
+	if st.split != nil {
+		record, term, ok = st.scanFunc()
+	} else {
+		record, term, ok = st.scanLiteral()
+	}
+	if !ok {
+		return false
+	}
+	st.history = append(st.history, record)
+	st.starts = append(st.starts, start)
+	st.terms = append(st.terms, term)
+	//An insert can leave the history more than one over its limit.
+	for st.limit > 0 && len(st.history) > st.limit {
+		st.history = st.history[1:]
+		st.starts = st.starts[1:]
+		st.terms = st.terms[1:]
+		st.base++
+	}
+	return true
+}
+
+// fill reads up to the next newline, or the given delimiter, into chunk,
+// noting the end of the input.
+func (st *StreamTape) fill(delim byte) []byte {
+	chunk, err := st.reader.ReadBytes(delim)
+	st.read += len(chunk)
+	if err != nil {
+		st.eof = true
+		if !errors.Is(err, io.EOF) {
+			st.err = err
+		}
+	}
+	return chunk
+}
+
+func (st *StreamTape) scanLiteral() (string, string, bool) {
+	if st.eof {
+		return "", "", false
 	}
+	sep := []byte(st.seperator)
+	var record []byte
+	for !st.eof {
+		record = append(record, st.fill(sep[len(sep)-1])...)
+		if bytes.HasSuffix(record, sep) {
+			return string(record[:len(record)-len(sep)]), st.seperator, true
+		}
+	}
+	return string(record), "", len(record) > 0
+}
 
-	if rs.mmap[rs.pos] < utf8.RuneSelf {
-		return rune(rs.mmap[rs.pos]), 1, nil
+// scanFunc reads until the seperator is found, or to the end of the input
+// if there isn't one.
+func (st *StreamTape) scanFunc() (string, string, bool) {
+	begin, end, ok := st.split(&pendingReader{st: st})
+	if !ok {
+		for !st.eof {
+			st.pending = append(st.pending, st.fill('\n')...)
+		}
+		record := string(st.pending)
+		st.pending = nil
+		return record, "", len(record) > 0
 	}
+	record, term := string(st.pending[:begin]), string(st.pending[begin:end])
+	st.pending = st.pending[end:]
+	return record, term, true
+}
+
+// pendingReader reads the pending input for a SeperatorFunc, reading more
+// of the stream into it only once the SeperatorFunc reaches its end.
+type pendingReader struct {
+	st  *StreamTape
+	pos int
+}
 
-	r, width := utf8.DecodeRune(rs.mmap[rs.pos:])
-	if width > 1 {
-		return r, width, nil
+func (pr *pendingReader) ReadRune() (rune, int, error) {
+	st := pr.st
+	for !st.eof && (pr.pos >= len(st.pending) || !utf8.FullRune(st.pending[pr.pos:])) {
+		st.pending = append(st.pending, st.fill('\n')...)
 	}
-	return utf8.RuneError, 1, nil
+	if pr.pos >= len(st.pending) {
+		return 0, 0, io.EOF
+	}
+	c, size := utf8.DecodeRune(st.pending[pr.pos:])
+	pr.pos += size
+	return c, size, nil
+}
 
+func (pr *pendingReader) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart || offset < 0 {
+		return 0, errors.New("pendingReader.Seek: only offsets from the start are supported")
+	}
+	pr.pos = int(offset)
+	return offset, nil
 }
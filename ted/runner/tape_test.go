@@ -0,0 +1,309 @@
+package runner
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+var benchSize = flag.Int64("tape.bench-size", 64<<20, "size in bytes of the input used by tape benchmarks")
+
+func TestStreamTapeNext(t *testing.T) {
+	tests := []struct {
+		input     string
+		seperator string
+		expected  []string
+	}{
+		{"a\nb\nc", "\n", []string{"a", "b", "c"}},
+		{"a\nb\nc\n", "\n", []string{"a", "b", "c"}},
+		{"a\n\nc\n", "\n", []string{"a", "", "c"}},
+		{"a--b--c--", "--", []string{"a", "b", "c"}},
+		{"a-b--c", "--", []string{"a-b", "c"}},
+		{"", "\n", []string{}},
+	}
+
+	for i, tt := range tests {
+		tape := NewStreamTape(strings.NewReader(tt.input), 0)
+		tape.Split(tt.seperator)
+		got := []string{}
+		for tape.Next() {
+			got = append(got, tape.Text())
+		}
+		if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("test[%d] - records = %q, want %q", i, got, tt.expected)
+		}
+	}
+}
+
+func TestStreamTapeHistory(t *testing.T) {
+	tape := NewStreamTape(strings.NewReader("a\nb\nc\nd\n"), 2)
+	for i := 0; i < 4; i++ {
+		tape.Next()
+	}
+	if tape.Text() != "d" {
+		t.Fatalf("Text() = %q, want %q", tape.Text(), "d")
+	}
+	if !tape.Prev() || tape.Text() != "c" {
+		t.Errorf("Prev() should move to %q, got %q", "c", tape.Text())
+	}
+	if tape.Prev() {
+		t.Errorf("Prev() past the history window should fail")
+	}
+	if !tape.Next() || tape.Text() != "c" {
+		t.Errorf("Next() after a failed Prev() should return to %q, got %q", "c", tape.Text())
+	}
+}
+
+func TestReversibleScannerNext(t *testing.T) {
+	tests := []struct {
+		input     string
+		seperator string
+		expected  []string
+	}{
+		{"a\nb\nc", "\n", []string{"a", "b", "c"}},
+		{"a\nb\nc\n", "\n", []string{"a", "b", "c"}},
+		{"a\n\nc\n", "\n", []string{"a", "", "c"}},
+		{"a--b--c--", "--", []string{"a", "b", "c"}},
+		{"a-b--c", "--", []string{"a-b", "c"}},
+		{"héllo\nwörld", "\n", []string{"héllo", "wörld"}},
+		{"", "\n", []string{}},
+	}
+
+	for i, tt := range tests {
+		tape := NewReversibleScanner(mmap.MMap(tt.input))
+		tape.Split(tt.seperator)
+		got := []string{}
+		for tape.Next() {
+			got = append(got, tape.Text())
+		}
+		if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+			t.Errorf("test[%d] - records = %q, want %q", i, got, tt.expected)
+		}
+	}
+}
+
+func TestReversibleScannerSeek(t *testing.T) {
+	tape := NewReversibleScanner(mmap.MMap("zero\none\ntwo\nthree"))
+	tests := []struct {
+		offset       int
+		whence       int
+		expectedText string
+		expectedErr  error
+	}{
+		{2, 0, "two", nil},
+		{-1, 1, "one", nil},
+		{-1, 2, "three", nil},
+		{0, 0, "zero", nil},
+		{4, 0, "zero", ErrEof},
+		{-1, 0, "zero", ErrBof},
+	}
+
+	for i, tt := range tests {
+		_, err := tape.Seek(tt.offset, tt.whence)
+		if err != tt.expectedErr {
+			t.Errorf("test[%d] - Seek(%d, %d) err = %v, want %v", i, tt.offset, tt.whence, err, tt.expectedErr)
+		}
+		if tape.Text() != tt.expectedText {
+			t.Errorf("test[%d] - Seek(%d, %d) text = %q, want %q", i, tt.offset, tt.whence, tape.Text(), tt.expectedText)
+		}
+	}
+}
+
+func TestByteOffset(t *testing.T) {
+	input := "zero\none\n\nthree"
+	expected := []int{0, 5, 9, 10}
+	tapes := map[string]Tape{
+		"StringTape":        NewStringTape(input),
+		"ReversibleScanner": NewReversibleScanner(mmap.MMap(input)),
+		"StreamTape":        NewStreamTape(strings.NewReader(input), 0),
+	}
+
+	for name, tape := range tapes {
+		if tape.ByteOffset() != -1 {
+			t.Errorf("%s - ByteOffset() before the first record = %d, want -1", name, tape.ByteOffset())
+		}
+		for i, want := range expected {
+			if !tape.Next() {
+				t.Fatalf("%s - Next() failed at record %d", name, i)
+			}
+			if tape.ByteOffset() != want {
+				t.Errorf("%s - record %d ByteOffset() = %d, want %d", name, i, tape.ByteOffset(), want)
+			}
+		}
+		tape.Prev()
+		tape.Prev()
+		if tape.ByteOffset() != expected[1] {
+			t.Errorf("%s - ByteOffset() after Prev() = %d, want %d", name, tape.ByteOffset(), expected[1])
+		}
+	}
+}
+
+func TestSplitFunc(t *testing.T) {
+	tests := []struct {
+		input    string
+		split    SeperatorFunc
+		expected []string // each record followed by its terminator
+	}{
+		{"a\n\n\nb\nc\n\n", RegexSeperator(regexp.MustCompile(`\n\n+`)), []string{"a", "\n\n\n", "b\nc", "\n\n"}},
+		{"a1b22c", RegexSeperator(regexp.MustCompile(`[0-9]+`)), []string{"a", "1", "b", "22", "c", ""}},
+		{"x 1\n y\nx 2\n", RegexSeperator(regexp.MustCompile(`(\n)x|\n$`)), []string{"x 1\n y", "\n", "x 2", "\n"}},
+		{"abc", RegexSeperator(regexp.MustCompile(`z*`)), []string{"abc", ""}},
+		{"", RegexSeperator(regexp.MustCompile(`\n`)), []string{}},
+		{"a,\"b\nc\"\r\nd,\"\"\"e\n\"\n", CSVSeperator, []string{"a,\"b\nc\"", "\r\n", "d,\"\"\"e\n\"", "\n"}},
+	}
+
+	for i, tt := range tests {
+		tapes := map[string]Tape{
+			"StringTape":        NewStringTape(tt.input),
+			"ReversibleScanner": NewReversibleScanner(mmap.MMap(tt.input)),
+			"StreamTape":        NewStreamTape(strings.NewReader(tt.input), 0),
+		}
+		for name, tape := range tapes {
+			tape.SplitFunc(tt.split)
+			got := []string{}
+			for tape.Next() {
+				got = append(got, tape.Text(), tape.Terminator())
+			}
+			if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("test[%d] %s - records = %q, want %q", i, name, got, tt.expected)
+			}
+		}
+	}
+}
+
+// runeCounter counts the runes a SeperatorFunc reads.
+type runeCounter struct {
+	RecordReader
+	runes int
+}
+
+func (rc *runeCounter) ReadRune() (rune, int, error) {
+	rc.runes++
+	return rc.RecordReader.ReadRune()
+}
+
+func TestSplitFuncReadsOnce(t *testing.T) {
+	input := "\"" + strings.Repeat("a\n", 1000) + "\"\n\n\n" + strings.Repeat("b\n", 1000) + "\n"
+	splits := map[string]SeperatorFunc{
+		"CSVSeperator":   CSVSeperator,
+		"RegexSeperator": RegexSeperator(regexp.MustCompile(`\n\n+`)),
+	}
+	for name, split := range splits {
+		runes := 0
+		tape := NewStreamTape(strings.NewReader(input), 0)
+		tape.SplitFunc(func(in RecordReader) (int, int, bool) {
+			rc := &runeCounter{RecordReader: in}
+			begin, end, ok := split(rc)
+			runes += rc.runes
+			return begin, end, ok
+		})
+		for tape.Next() {
+		}
+		if runes > 2*len(input) {
+			t.Errorf("%s - read %d runes to split %d bytes", name, runes, len(input))
+		}
+	}
+}
+
+func TestTapeEdits(t *testing.T) {
+	input := "a\nb\nc\nd"
+	tapes := map[string]Tape{
+		"StringTape":        NewStringTape(input),
+		"ReversibleScanner": NewReversibleScanner(mmap.MMap(input)),
+		"StreamTape":        NewStreamTape(strings.NewReader(input), 0),
+	}
+
+	for name, tape := range tapes {
+		if tape.Write("x") || tape.Insert("x", true) || tape.Delete() {
+			t.Errorf("%s - edit succeeded before the first record", name)
+		}
+		tape.Next() // a
+		tape.Insert("before", false)
+		tape.Next() // b
+		tape.Write("B")
+		tape.Insert("after", true)
+		tape.Next() // after
+		tape.Next() // c
+		tape.Delete()
+		if tape.Text() != "after" {
+			t.Errorf("%s - Text() after Delete() = %q, want %q", name, tape.Text(), "after")
+		}
+		tape.Next() // d
+
+		tape.Seek(0, io.SeekStart)
+		got := []string{tape.Text()}
+		for tape.Next() {
+			got = append(got, tape.Text())
+		}
+		expected := []string{"before", "a", "B", "after", "d"}
+		if strings.Join(got, "|") != strings.Join(expected, "|") {
+			t.Errorf("%s - records after edits = %q, want %q", name, got, expected)
+		}
+	}
+}
+
+// writeBenchFile writes *benchSize bytes of records of recordLen bytes and
+// maps the result into memory.
+func writeBenchFile(b *testing.B, recordLen int) mmap.MMap {
+	b.Helper()
+	f, err := os.Create(filepath.Join(b.TempDir(), "input"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	record := strings.Repeat("x", recordLen-1) + "\n"
+	for written := int64(0); written < *benchSize; written += int64(len(record)) {
+		w.WriteString(record)
+	}
+	if err := w.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	m, err := mmap.Map(f, mmap.RDONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		m.Unmap()
+		f.Close()
+	})
+	return m
+}
+
+func benchmarkReversibleScanner(b *testing.B, recordLen int) {
+	m := writeBenchFile(b, recordLen)
+	b.SetBytes(int64(len(m)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tape := NewReversibleScanner(m)
+		for tape.Next() {
+		}
+	}
+}
+
+func BenchmarkReversibleScannerShortRecords(b *testing.B) {
+	benchmarkReversibleScanner(b, 80)
+}
+
+func BenchmarkReversibleScannerLongRecords(b *testing.B) {
+	benchmarkReversibleScanner(b, 1<<20)
+}
+
+func BenchmarkReversibleScannerRewind(b *testing.B) {
+	m := writeBenchFile(b, 80)
+	b.SetBytes(int64(len(m)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tape := NewReversibleScanner(m)
+		for tape.Next() {
+		}
+		for tape.Prev() {
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Package ted runs ted programs from Go.
+//
+//	prog, err := ted.Compile(`/ERROR/ { println $_ }`)
+//	if err != nil {
+//		return err
+//	}
+//	err = prog.Run(ctx, in, out, ted.Options{NoPrint: true})
+//
+// A Program is never modified once compiled, and every Run gets its own
+// runner, so one Program can be run from many goroutines at once.
+package ted
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ahalbert/ted/ted/ast"
+	"github.com/ahalbert/ted/ted/checker"
+	"github.com/ahalbert/ted/ted/graph"
+	"github.com/ahalbert/ted/ted/lexer"
+	"github.com/ahalbert/ted/ted/parser"
+	"github.com/ahalbert/ted/ted/runner"
+)
+
+// DefaultHistory is how many records are kept for rewinding when the input
+// is streamed rather than read from a file.
+const DefaultHistory = 10000
+
+// Program is a compiled ted program.
+type Program struct {
+	name   string
+	source string
+	fsa    ast.FSA
+}
+
+// Options control a single run of a Program.
+type Options struct {
+	Variables map[string]string // initial variables, like --var; these override Separator and NoPrint
+	Separator string            // record separator, "\n" if empty
+	RegexSep  bool              // Separator is a regular expression; see runner.RegexSeperator
+	FieldSep  string            // field separator for $F1..$FN, runs of whitespace if empty
+	Format    string            // "csv" or "tsv" to read rows with quoting, "jsonl" for JSON Lines, "text" if empty
+	NoPrint   bool              // don't print each record after processing it
+	History   int               // records kept for rewinding streamed input, DefaultHistory if 0, every record if negative
+	PerFile   bool              // with RunFiles, start each file in the start state instead of where the last file left off
+	LoopLimit int               // times a while loop may run or ted may stay paused, runner.DefaultLoopLimit if 0, no limit if negative
+}
+
+// ParseError is returned by Compile when the program does not parse.
+type ParseError struct {
+	Errors []string
+}
+
+func (e *ParseError) Error() string {
+	return strings.Join(e.Errors, "\n")
+}
+
+// Compile parses src into a Program.
+func Compile(src string) (*Program, error) {
+	return CompileNamed("", src)
+}
+
+// CompileNamed is Compile for a program with a name, such as the file it was
+// read from, which is shown in runtime errors.
+func CompileNamed(name string, src string) (*Program, error) {
+	p := parser.New(lexer.New(src))
+	fsa, errors := p.ParseFSA()
+	if len(errors) > 0 {
+		return nil, &ParseError{Errors: errors}
+	}
+	return &Program{name: name, source: src, fsa: fsa}, nil
+}
+
+// Name returns the name the program was compiled with.
+func (p *Program) Name() string {
+	return p.name
+}
+
+// Run runs the program over in, writing to out. Regular files are memory
+// mapped so the head can move freely; any other reader is streamed, keeping
+// opts.History records for rewinding.
+//
+// The returned error is a *runner.RuntimeError if the program fails, a
+// *runner.IOError if reading or writing fails, a *runner.ExitError if the
+// program runs exit with a non-zero status, or ctx.Err() if ctx is done
+// before the input is used up. ctx is checked between records.
+func (p *Program) Run(ctx context.Context, in io.Reader, out io.Writer, opts Options) error {
+	r := p.newRunner(opts)
+	r.Context = ctx
+
+	if f, ok := in.(*os.File); ok {
+		info, err := f.Stat()
+		if err == nil && info.Mode().IsRegular() {
+			return r.RunFSAFromFile(f, out)
+		}
+	}
+	history := opts.History
+	if history == 0 {
+		history = DefaultHistory
+	}
+	return r.RunFSAFromReader(in, history, out)
+}
+
+// RunFiles runs one machine over the named files in turn, writing to out.
+// BEGIN and END run once, and BEGINFILE and ENDFILE run around each file. By
+// default the machine carries on from one file to the next as if they were
+// concatenated; opts.PerFile sends it back to its start state for each file.
+// Files that can't be read are skipped, and a *runner.IOError for each is
+// joined with the error from the run.
+func (p *Program) RunFiles(ctx context.Context, names []string, out io.Writer, opts Options) error {
+	r := p.newRunner(opts)
+	r.Context = ctx
+	return r.RunFSAFromFiles(names, opts.PerFile, out)
+}
+
+// Check reports problems found in the program without running it.
+func (p *Program) Check(opts Options) []checker.Diagnostic {
+	return checker.Check(p.fsa, opts.variables())
+}
+
+// WriteGraph writes the program's state machine to w as "dot" or "mermaid".
+func (p *Program) WriteGraph(w io.Writer, format string) error {
+	return graph.New(p.newRunner(Options{})).Write(w, format)
+}
+
+func (p *Program) String() string {
+	return p.fsa.String()
+}
+
+func (p *Program) newRunner(opts Options) *runner.Runner {
+	r := runner.NewRunner(p.fsa, opts.variables())
+	r.ProgramName = p.name
+	r.Program = p.source
+	return r
+}
+
+// variables builds a fresh variable map, since the runner writes to it.
+func (o Options) variables() map[string]string {
+	variables := make(map[string]string)
+	variables["$RS"] = "\n"
+	if o.Separator != "" {
+		variables["$RS"] = o.Separator
+	}
+	if o.RegexSep {
+		variables["$RSMODE"] = "regex"
+	}
+	if o.FieldSep != "" {
+		variables["$FS"] = o.FieldSep
+	}
+	if o.Format != "" {
+		variables["$FORMAT"] = o.Format
+	}
+	if o.LoopLimit > 0 {
+		variables["$LOOPLIMIT"] = strconv.Itoa(o.LoopLimit)
+	} else if o.LoopLimit < 0 {
+		variables["$LOOPLIMIT"] = "0"
+	}
+	variables["$PRINTMODE"] = "print"
+	if o.NoPrint {
+		variables["$PRINTMODE"] = "noprint"
+	}
+	for k, v := range o.Variables {
+		variables[k] = v
+	}
+	return variables
+}
@@ -0,0 +1,155 @@
+package ted
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ahalbert/ted/ted/runner"
+)
+
+func TestCompileError(t *testing.T) {
+	_, err := Compile(`{ print`)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected *ParseError, got %v", err)
+	}
+	if len(parseErr.Errors) == 0 {
+		t.Errorf("expected at least one parse error")
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		program string
+		input   string
+		opts    Options
+		output  string
+	}{
+		{`/b/ do s/b/B/`, "a\nb\nc\n", Options{}, "a\nB\nc\n"},
+		{`/b/ println $_`, "a\nb\nc\n", Options{NoPrint: true}, "b\n"},
+		{`println $_`, "a;b", Options{NoPrint: true, Separator: ";"}, "a\nb\n"},
+		{`println who`, "a\n", Options{NoPrint: true, Variables: map[string]string{"who": "world"}}, "world\n"},
+		{`print`, "a\n", Options{Variables: map[string]string{"$PRINTMODE": "noprint"}}, "a"},
+		{`do s/a/A/`, "a\n\nb\n\n\n", Options{Separator: `\n\n+`, RegexSep: true}, "A\n\nb\n\n\n"},
+		{`/1/ let $.b = "y,z"`, "a,b\r\n1,x\r\n2,w", Options{Format: "csv"}, "a,b\r\n1,\"y,z\"\r\n2,w"},
+		{`END: { let n = 0 while true { let n = n + 1 if n == 3 break } println n }`, "a\n", Options{NoPrint: true, LoopLimit: -1}, "3\n"},
+	}
+
+	for i, tt := range tests {
+		prog, err := Compile(tt.program)
+		if err != nil {
+			t.Fatalf("test[%d] - compile error: %v", i, err)
+		}
+		var out bytes.Buffer
+		if err := prog.Run(context.Background(), strings.NewReader(tt.input), &out, tt.opts); err != nil {
+			t.Fatalf("test[%d] - run error: %v", i, err)
+		}
+		if out.String() != tt.output {
+			t.Errorf("test[%d] - output wrong. expected=%q, got=%q", i, tt.output, out.String())
+		}
+	}
+}
+
+func TestRunConcurrently(t *testing.T) {
+	prog, err := Compile(`
+BEGIN: let count = 0
+/x/ { let count = count + 1 do s/x/y/g }
+END: println count
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 16; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			input := strings.Repeat("x\n", n)
+			var out bytes.Buffer
+			if err := prog.Run(context.Background(), strings.NewReader(input), &out, Options{}); err != nil {
+				t.Errorf("run %d - error: %v", n, err)
+				return
+			}
+			expected := strings.Repeat("y\n", n) + fmt.Sprintf("%d\n", n)
+			if out.String() != expected {
+				t.Errorf("run %d - output wrong. expected=%q, got=%q", n, expected, out.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRunErrors(t *testing.T) {
+	prog, err := CompileNamed("missing.ted", `/b/ println $missing`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = prog.Run(context.Background(), strings.NewReader("a\nb\n"), &bytes.Buffer{}, Options{})
+	var runtimeErr *runner.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *runner.RuntimeError, got %v", err)
+	}
+	if runtimeErr.Record != 2 {
+		t.Errorf("expected error on record 2, got %d", runtimeErr.Record)
+	}
+	if prog.Name() != "missing.ted" || !strings.Contains(err.Error(), "missing.ted:1:") {
+		t.Errorf("expected the error to name missing.ted, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = prog.Run(ctx, strings.NewReader("a\nb\n"), &bytes.Buffer{}, Options{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRunFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first")
+	second := filepath.Join(dir, "second")
+	os.WriteFile(first, []byte("a\nSTART\nb\n"), 0644)
+	os.WriteFile(second, []byte("c\nd\n"), 0644)
+
+	prog, err := Compile(`
+BEGINFILE: println "file"
+wait: /START/ -> inside
+inside: { print $FNR print " " println $NR }
+ENDFILE: println "done"
+END: println $NR
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts   Options
+		files  []string
+		output string
+		ioErr  bool
+	}{
+		{Options{NoPrint: true}, []string{first, second}, "file\n3 3\ndone\nfile\n1 4\n2 5\ndone\n5\n", false},
+		{Options{NoPrint: true, PerFile: true}, []string{first, second}, "file\n3 3\ndone\nfile\ndone\n5\n", false},
+		{Options{NoPrint: true}, []string{filepath.Join(dir, "missing"), first}, "file\n3 3\ndone\n3\n", true},
+	}
+
+	for i, tt := range tests {
+		var out bytes.Buffer
+		err := prog.RunFiles(context.Background(), tt.files, &out, tt.opts)
+		var ioErr *runner.IOError
+		if errors.As(err, &ioErr) != tt.ioErr || (!tt.ioErr && err != nil) {
+			t.Errorf("test[%d] - expected I/O error=%t, got err=%v", i, tt.ioErr, err)
+		}
+		if out.String() != tt.output {
+			t.Errorf("test[%d] - output wrong. expected=%q, got=%q", i, tt.output, out.String())
+		}
+	}
+}
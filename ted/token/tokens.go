@@ -3,10 +3,12 @@ package token
 type TokenType string
 
 type Token struct {
-	Type     TokenType
-	Literal  string
-	LineNum  int
-	Position int
+	Type        TokenType
+	Literal     string
+	LineNum     int
+	Position    int
+	EndLineNum  int // the line the token ends on
+	EndPosition int // the column just past the token's last character
 }
 
 const (
@@ -34,15 +36,27 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
+	CONCAT   = ".."
 
-	LT = "<"
-	GT = ">"
+	LT    = "<"
+	GT    = ">"
+	LT_EQ = "<="
+	GT_EQ = ">="
 
 	EQ     = "=="
 	NOT_EQ = "!="
 
-	LPAREN = "("
-	RPAREN = ")"
+	MATCH     = "~"
+	NOT_MATCH = "!~"
+
+	AND = "&&"
+	OR  = "||"
+
+	LPAREN   = "("
+	RPAREN   = ")"
+	LBRACKET = "["
+	RBRACKET = "]"
 
 	//Keywords
 	DO       = "DO"
@@ -59,9 +73,21 @@ const (
 	FASTFWD  = "FASTFORWARD"
 	PAUSE    = "PAUSE"
 	PLAY     = "PLAY"
+	SEEK     = "SEEK"
+	MARK     = "MARK"
+	GOTOMARK = "GOTOMARK" // goto, only used as goto mark NAME; transitions are ->
+	WRITE    = "WRITE"
+	INSERT   = "INSERT"
+	DELETE   = "DELETE"
 	IF       = "IF"
+	FOR      = "FOR"
+	IN       = "IN"
+	WHILE    = "WHILE"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	EXIT     = "EXIT"
 	FUNCTION = "FUNCTION"
 )
 
@@ -79,10 +105,22 @@ var keywords = map[string]TokenType{
 	"fastforward": FASTFWD,
 	"pause":       PAUSE,
 	"play":        PLAY,
+	"seek":        SEEK,
+	"mark":        MARK,
+	"goto":        GOTOMARK,
+	"write":       WRITE,
+	"insert":      INSERT,
+	"delete":      DELETE,
 	"if":          IF,
+	"for":         FOR,
+	"in":          IN,
+	"while":       WHILE,
+	"break":       BREAK,
+	"continue":    CONTINUE,
 	"else":        ELSE,
 	"function":    FUNCTION,
 	"return":      RETURN,
+	"exit":        EXIT,
 }
 
 func LookupIdent(ident string) TokenType {